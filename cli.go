@@ -0,0 +1,575 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"change_sn/vcu"
+)
+
+// Exit codes returned by the non-interactive subcommands.
+const (
+	exitOK           = 0
+	exitFailure      = 1
+	exitUsage        = 2
+	exitIO           = 3
+	exitInvalidDump  = 4
+	exitInvalidValue = 5
+	exitUnsupported  = 6
+)
+
+// layoutDir is where user layout profiles are loaded from.
+const layoutDir = "layouts"
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"info", "info --in dump.bin [--format text|json|yaml|csv] [--layout NAME]", runInfo},
+		{"verify", "verify --in dump.bin [--format text|json|yaml|csv] [--layout NAME]", runVerify},
+		{"set", "set --in dump.bin [--out patched.bin] [--overwrite] [--serial SN] [--mileage N] [--speed N] [--key-from other.bin] [--force] [--layout NAME]", runSet},
+		{"copy-key", "copy-key --in dump.bin --from other.bin [--out patched.bin] [--overwrite] [--force]", runCopyKey},
+		{"write", "write --template VERSION|file.bin --serial SN --mileage N --speed N --key-from own.bin [--out patched.bin] [--overwrite]", runWrite},
+		{"check", "check --in dump.bin [--format text|json|yaml]", runCheck},
+		{"repair", "repair --in dump.bin [--out repaired.bin] [--overwrite] [--from A|B]", runRepair},
+		{"merge", "merge --in config.bin --template VERSION|file.bin [--firmware VERSION] [--force] [--out merged.bin] [--overwrite]", runMerge},
+		{"undo", "undo [--journal patched.json] [--out restored.bin] [--overwrite] patched.bin", runUndo},
+		{"stage", "stage --dump dump.bin --app VERSION|app.bin [--force] [--out staged.bin] [--overwrite]", runStage},
+		{"descriptor", "descriptor --in dump.bin [--format text|json|yaml] [--arm [--length N] | --clear] [--force] [--out patched.bin] [--overwrite]", runDescriptor},
+		{"read", "read --openocd HOST:PORT|--gdb HOST:PORT|--uart PORT [--gdb-attach] [--baud N] [--config-only] [--out dump.bin] [--overwrite]", runRead},
+		{"flash", "flash --in patched.bin --openocd HOST:PORT|--gdb HOST:PORT|--uart PORT [--gdb-attach] [--baud N] [--reset]", runFlash},
+		{"live", "live info --port PORT [--baud N] [--dump dump.bin] [--layout NAME] [--format text|json|yaml]\n" +
+			"  live record --port PORT [--baud N] [--interval 500ms] [--duration D] [--out log.csv] [--overwrite] [--format csv|jsonl]", runLive},
+		{"emulate", "emulate --dump dump.bin --pty|--port PORT [--baud N]", runEmulate},
+		{"serial", "serial [--layout NAME] SN...", runSerial},
+		{"map", "map --in dump.bin [--format text|json|yaml]", runMap},
+		{"diff", "diff [--format text|json|yaml] [--all] a.bin b.bin", runDiff},
+		{"templates", "templates [--generate DUMPS]", runTemplates},
+		{"layouts", "layouts [--format text|json|yaml]", runLayouts},
+		{"keys", "keys [--format text|json|yaml|csv] [dump.bin...]", runKeys},
+		{"help", "help", runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand runs a subcommand and returns the process exit code.
+func runCommand(cmd *command, args []string) int {
+	err := cmd.run(args)
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "❌ %s: %v\n", cmd.name, err)
+	return exitCode(err)
+}
+
+// usageError marks an error caused by bad command line arguments.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func exitCode(err error) int {
+	var ue *usageError
+	var pe *fs.PathError
+	var ne net.Error
+	switch {
+	case errors.As(err, &ue):
+		return exitUsage
+	case errors.Is(err, vcu.ErrSize), errors.Is(err, vcu.ErrHeader), errors.Is(err, vcu.ErrInconsistent),
+		errors.Is(err, vcu.ErrImage), errors.Is(err, vcu.ErrAppImage), errors.Is(err, vcu.ErrStaging):
+		return exitInvalidDump
+	case errors.Is(err, vcu.ErrLayout), errors.Is(err, vcu.ErrTemplateNotFound):
+		return exitUsage
+	case errors.Is(err, vcu.ErrManifest), errors.Is(err, vcu.ErrTemplateHash),
+		errors.Is(err, vcu.ErrJournal), errors.Is(err, vcu.ErrJournalMismatch),
+		errors.Is(err, errLiveMismatch):
+		return exitInvalidDump
+	case errors.Is(err, vcu.ErrSerialFormat), errors.Is(err, vcu.ErrNoSerials),
+		errors.Is(err, vcu.ErrOutOfRange), errors.Is(err, vcu.ErrKeyLength),
+		errors.Is(err, vcu.ErrAmbiguousCopy), errors.Is(err, vcu.ErrUnknownCopy):
+		return exitInvalidValue
+	case errors.Is(err, vcu.ErrUnsupportedFirmware), errors.Is(err, errIncompatible),
+		errors.Is(err, vcu.ErrDescriptorFormat):
+		return exitUnsupported
+	case errors.As(err, &pe), errors.As(err, &ne):
+		return exitIO
+	}
+	return exitFailure
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		if cmd := findCommand(name); cmd != nil {
+			_, _ = fmt.Fprintf(flags.Output(), "usage: %s %s\n", os.Args[0], cmd.usage)
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	positional, err := parseFlagsArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageErrorf("unexpected argument %q", positional[0])
+	}
+	return nil
+}
+
+// parseFlagsArgs is parseFlags for commands that take positional arguments.
+// Flags may appear before or after them.
+func parseFlagsArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, &usageError{msg: err.Error()}
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// loadDump reads a dump and checks its bootloader header.
+func loadDump(path string) (*vcu.Dump, error) {
+	if path == "" {
+		return nil, usageErrorf("missing input file")
+	}
+	dump, err := vcu.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = dump.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return dump, nil
+}
+
+func runHelp(args []string) error {
+	_, _ = fmt.Fprintf(os.Stderr, "usage: %s [-v | -k]\n       %s <command> [flags]\n\ncommands:\n", os.Args[0], os.Args[0])
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+	}
+	return nil
+}
+
+func runInfo(args []string) error {
+	flags := newFlagSet("info")
+	in := flags.String("in", "", "dump file to inspect")
+	format := flags.String("format", formatText, "output format: text, json, yaml or csv")
+	layoutName := flags.String("layout", "", "layout profile name or file (default: detect)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	layout, err := resolveLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+	return writeReports(os.Stdout, *format, []dumpReport{newReport(*in, dump.Bytes(), layout)}, false)
+}
+
+// runVerify always prints the report, then fails if the dump is invalid.
+func runVerify(args []string) error {
+	flags := newFlagSet("verify")
+	in := flags.String("in", "", "dump file to verify")
+	format := flags.String("format", formatText, "output format: text, json, yaml or csv")
+	layoutName := flags.String("layout", "", "layout profile name or file (default: detect)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *in == "" {
+		return usageErrorf("missing input file")
+	}
+	layout, err := resolveLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+
+	report, err := readReport(*in, layout)
+	if err != nil {
+		return err
+	}
+	if err = writeReports(os.Stdout, *format, []dumpReport{report}, false); err != nil {
+		return err
+	}
+	return report.err()
+}
+
+// runKeys lists the secret keys of the given dumps, or of every dump file in
+// the current directory.
+func runKeys(args []string) error {
+	flags := newFlagSet("keys")
+	format := flags.String("format", formatText, "output format: text, json, yaml or csv")
+	files, err := parseFlagsArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if err = checkFormat(*format); err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		files = getBinFiles(".")
+	}
+
+	reports := make([]dumpReport, 0, len(files))
+	for _, f := range files {
+		report, err := readReport(f, nil)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, report)
+	}
+	return writeReports(os.Stdout, *format, reports, true)
+}
+
+// runLayouts lists the registered layout profiles.
+func runLayouts(args []string) error {
+	flags := newFlagSet("layouts")
+	format := flags.String("format", formatText, "output format: text, json or yaml")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	layouts := vcu.Layouts()
+	switch *format {
+	case formatJSON, formatYAML:
+		return writeValue(os.Stdout, *format, layouts)
+	case formatText:
+	default:
+		return usageErrorf("unknown format %q (want text, json or yaml)", *format)
+	}
+
+	for _, l := range layouts {
+		fmt.Printf("%s: firmware %s\n", l.Name, strings.Join(l.Versions, ", "))
+		fmt.Printf("   serial %q x%d, mileage %s/%s, speed %v, key %s+%d\n",
+			l.SerialPrefix, l.SerialLength, l.MileageA, l.MileageB, l.Speeds, l.KeyOffset, l.KeyLength)
+	}
+	return nil
+}
+
+// resolveLayout returns the profile called name, loading it from a file if
+// no registered profile matches. An empty name means autodetect.
+func resolveLayout(name string) (*vcu.Layout, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if l, ok := vcu.LookupLayout(name); ok {
+		return l, nil
+	}
+	if _, err := os.Stat(name); err != nil {
+		return nil, usageErrorf("unknown layout %q", name)
+	}
+	return vcu.LoadLayoutFile(name)
+}
+
+// loadLayouts registers the profile files found in a layouts directory next
+// to the executable and in the current directory, in that order.
+func loadLayouts() error {
+	var dirs []string
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(exe), layoutDir))
+	}
+	dirs = append(dirs, layoutDir)
+
+	for _, dir := range dirs {
+		if err := vcu.LoadLayoutDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSerial decodes serial numbers without touching any dump.
+func runSerial(args []string) error {
+	flags := newFlagSet("serial")
+	layoutName := flags.String("layout", vcu.DefaultLayout.Name, "layout profile name or file")
+	serials, err := parseFlagsArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(serials) == 0 {
+		return usageErrorf("missing serial number")
+	}
+	layout, err := resolveLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range serials {
+		sn, err := vcu.ParseSerial(s, layout)
+		if err != nil {
+			return err
+		}
+		printSerial(sn)
+		for _, w := range sn.Warnings(layout) {
+			fmt.Println("⚠️", w)
+		}
+	}
+	return nil
+}
+
+// checkFirmware refuses to patch a firmware whose field offsets are known
+// not to match unless force is set, and warns when it cannot be detected.
+func checkFirmware(dump *vcu.Dump, force bool) error {
+	fw := dump.DetectFirmware()
+	err := fw.Check()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, vcu.ErrUnknownFirmware), errors.Is(err, vcu.ErrUnverifiedFirmware), force:
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ %v, field offsets may be wrong\n", err)
+		return nil
+	}
+	return fmt.Errorf("%w (use --force to patch anyway)", err)
+}
+
+// patchFlags holds the field edits shared by set and write.
+type patchFlags struct {
+	serial  *string
+	mileage *string
+	speed   *string
+	keyFrom *string
+	force   *bool
+	layout  *string
+}
+
+func addPatchFlags(flags *flag.FlagSet) patchFlags {
+	return patchFlags{
+		serial:  flags.String("serial", "", "new serial number (14 characters)"),
+		mileage: flags.String("mileage", "", "new mileage in 0.1 km units (0–65535)"),
+		speed:   flags.String("speed", "", "new speed limit (1–125)"),
+		keyFrom: flags.String("key-from", "", "dump to copy the secret key from"),
+		force:   flags.Bool("force", false, "patch even if the firmware is not supported"),
+		layout:  flags.String("layout", "", "layout profile name or file (default: detect)"),
+	}
+}
+
+func (p patchFlags) apply(dump *vcu.Dump) error {
+	layout, err := resolveLayout(*p.layout)
+	if err != nil {
+		return err
+	}
+	if layout != nil {
+		dump.SetLayout(layout)
+	} else if err = checkFirmware(dump, *p.force); err != nil {
+		return err
+	}
+	if *p.serial != "" {
+		sn, err := vcu.ParseSerial(*p.serial, dump.Layout())
+		if err != nil {
+			return err
+		}
+		for _, w := range sn.Warnings(dump.Layout()) {
+			_, _ = fmt.Fprintln(os.Stderr, "⚠️", w)
+		}
+		if _, err = dump.SetSerial(sn.Raw); err != nil {
+			return err
+		}
+	}
+	if *p.mileage != "" {
+		v, err := strconv.Atoi(*p.mileage)
+		if err != nil {
+			return usageErrorf("invalid mileage %q", *p.mileage)
+		}
+		if err = dump.SetMileage(v); err != nil {
+			return err
+		}
+	}
+	if *p.speed != "" {
+		v, err := strconv.Atoi(*p.speed)
+		if err != nil {
+			return usageErrorf("invalid speed %q", *p.speed)
+		}
+		if err = dump.SetSpeed(v); err != nil {
+			return err
+		}
+	}
+	if *p.keyFrom != "" {
+		source, err := loadDump(*p.keyFrom)
+		if err != nil {
+			return err
+		}
+		if err = dump.CopyKeyFrom(source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputFlags holds the output options shared by the patching commands.
+type outputFlags struct {
+	out       *string
+	overwrite *bool
+}
+
+func addOutputFlags(flags *flag.FlagSet) outputFlags {
+	return outputFlags{
+		out:       flags.String("out", "", "output file, .bin, .hex or .s19 (default: <input>.patched.<ext>, numbered if taken)"),
+		overwrite: flags.Bool("overwrite", false, "replace an existing output file and journal"),
+	}
+}
+
+// path returns --out, or a free name derived from in, next to it.
+func (o outputFlags) path(in string) string {
+	if *o.out != "" {
+		return *o.out
+	}
+	return outputName(in, "patched")
+}
+
+// writeDump saves the patched dump with its change journal.
+func writeDump(out string, original, dump *vcu.Dump, opts saveOptions) error {
+	journal, err := saveDump(out, original, dump, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println("✅ All changes written to:", out)
+	fmt.Println("📝 Change journal written to:", journal)
+	return nil
+}
+
+func runSet(args []string) error {
+	flags := newFlagSet("set")
+	in := flags.String("in", "", "dump file to patch")
+	output := addOutputFlags(flags)
+	patch := addPatchFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+	original := dump.Clone()
+	if err = patch.apply(dump); err != nil {
+		return err
+	}
+	return writeDump(output.path(*in), original, dump, saveOptions{
+		in:        *in,
+		backup:    true,
+		overwrite: *output.overwrite,
+		keySource: *patch.keyFrom,
+	})
+}
+
+func runCopyKey(args []string) error {
+	flags := newFlagSet("copy-key")
+	in := flags.String("in", "", "dump file to patch")
+	from := flags.String("from", "", "dump to copy the secret key from")
+	output := addOutputFlags(flags)
+	force := flags.Bool("force", false, "patch even if the firmware is not supported")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return usageErrorf("missing --from")
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+	if err = checkFirmware(dump, *force); err != nil {
+		return err
+	}
+	original := dump.Clone()
+	source, err := loadDump(*from)
+	if err != nil {
+		return err
+	}
+	if err = dump.CopyKeyFrom(source); err != nil {
+		return err
+	}
+	return writeDump(output.path(*in), original, dump, saveOptions{
+		in:        *in,
+		backup:    true,
+		overwrite: *output.overwrite,
+		keySource: *from,
+	})
+}
+
+// runWrite is the scriptable form of the "flash other version" menu: it
+// builds a new image from a firmware template and the scooter's own data.
+func runWrite(args []string) error {
+	flags := newFlagSet("write")
+	template := flags.String("template", "", "firmware template version from the manifest, or a dump file")
+	output := addOutputFlags(flags)
+	patch := addPatchFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *template == "" {
+		return usageErrorf("missing --template")
+	}
+	if *patch.serial == "" || *patch.mileage == "" || *patch.speed == "" || *patch.keyFrom == "" {
+		return usageErrorf("--serial, --mileage, --speed and --key-from are required")
+	}
+
+	dump, err := loadTemplate(*template)
+	if err != nil {
+		return err
+	}
+	original := dump.Clone()
+	if err = patch.apply(dump); err != nil {
+		return err
+	}
+	// The template is not the customer's data, so it is not backed up.
+	return writeDump(output.path(templateOutputBase(*template)), original, dump, saveOptions{
+		in:        *template,
+		overwrite: *output.overwrite,
+		keySource: *patch.keyFrom,
+	})
+}
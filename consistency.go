@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"change_sn/vcu"
+)
+
+type mismatchReport struct {
+	Field   string `json:"field" yaml:"field"`
+	OffsetA int    `json:"offset_a" yaml:"offset_a"`
+	OffsetB int    `json:"offset_b" yaml:"offset_b"`
+	A       string `json:"a" yaml:"a"`
+	B       string `json:"b" yaml:"b"`
+	Repair  string `json:"repair" yaml:"repair"`
+}
+
+type consistencyReport struct {
+	File          string           `json:"file" yaml:"file"`
+	Consistent    bool             `json:"consistent" yaml:"consistent"`
+	Healthy       string           `json:"healthy_copy" yaml:"healthy_copy"`
+	SpeedMajority int              `json:"speed_majority" yaml:"speed_majority"`
+	Mismatches    []mismatchReport `json:"mismatches" yaml:"mismatches"`
+}
+
+func newConsistencyReport(file string, c vcu.Consistency) consistencyReport {
+	r := consistencyReport{
+		File:          file,
+		Consistent:    c.OK(),
+		Healthy:       c.Healthy,
+		SpeedMajority: c.SpeedMajority,
+		Mismatches:    []mismatchReport{},
+	}
+	for _, m := range c.Mismatches {
+		r.Mismatches = append(r.Mismatches, mismatchReport{
+			Field:   m.Field.Name,
+			OffsetA: m.Field.Offset,
+			OffsetB: m.Field.Offset + vcu.ConfigBOffset - vcu.ConfigAOffset,
+			A:       m.A,
+			B:       m.B,
+			Repair:  m.Repairs,
+		})
+	}
+	return r
+}
+
+func writeConsistencyText(w io.Writer, r consistencyReport) {
+	if r.Consistent {
+		_, _ = fmt.Fprintln(w, "✅ Config copies A and B are consistent")
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, "❌ Config copies A and B disagree:")
+	for _, m := range r.Mismatches {
+		repair := m.Repair
+		if repair == "" {
+			repair = "needs --from A|B"
+		}
+		_, _ = fmt.Fprintf(w, "-> %-10s A 0x%05X: %s | B 0x%05X: %s | repair: %s\n",
+			m.Field, m.OffsetA, m.A, m.OffsetB, m.B, repair)
+	}
+	if r.Healthy != "" {
+		_, _ = fmt.Fprintf(w, "   Copy %s looks healthy\n", r.Healthy)
+	} else {
+		_, _ = fmt.Fprintln(w, "   Cannot tell which copy is healthy")
+	}
+}
+
+// runCheck reports mismatches between the config copies and fails when
+// there are any.
+func runCheck(args []string) error {
+	flags := newFlagSet("check")
+	in := flags.String("in", "", "dump file to check")
+	format := flags.String("format", formatText, "output format: text, json or yaml")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+
+	c := dump.CheckConsistency()
+	report := newConsistencyReport(*in, c)
+	switch *format {
+	case formatJSON, formatYAML:
+		err = writeValue(os.Stdout, *format, report)
+	case formatText:
+		writeConsistencyText(os.Stdout, report)
+	default:
+		return usageErrorf("unknown format %q (want text, json or yaml)", *format)
+	}
+	if err == nil && !c.OK() {
+		err = vcu.ErrInconsistent
+	}
+	return err
+}
+
+func runRepair(args []string) error {
+	flags := newFlagSet("repair")
+	in := flags.String("in", "", "dump file to repair")
+	output := addOutputFlags(flags)
+	from := flags.String("from", "", "copy to rebuild from, A or B (default: the healthy one)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+	original := dump.Clone()
+	n, err := dump.Repair(strings.ToUpper(*from))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Println("✅ Nothing to repair, no file written")
+		return nil
+	}
+	fmt.Printf("✅ Repaired %d field(s)\n", n)
+	return writeDump(output.path(*in), original, dump, saveOptions{
+		in:        *in,
+		backup:    true,
+		overwrite: *output.overwrite,
+	})
+}
+
+// confirmConsistency shows mismatches between the config copies and offers
+// to repair them before editing.
+func confirmConsistency(dump *vcu.Dump, verify *bool, reader *bufio.Reader) {
+	c := dump.CheckConsistency()
+	writeConsistencyText(os.Stdout, newConsistencyReport("", c))
+	if c.OK() || *verify {
+		return
+	}
+
+	source := c.Healthy
+	if source == "" {
+		fmt.Print("Repair from which copy? (A/B, empty to skip): ")
+	} else {
+		fmt.Printf("Do you want to repair from copy %s? (Y/N or A/B): ", source)
+	}
+	for {
+		answer, _ := reader.ReadString('\n')
+		answer = strings.ToUpper(strings.TrimSpace(answer))
+		switch {
+		case answer == vcu.CopyA || answer == vcu.CopyB:
+			source = answer
+		case answer == "Y" && source == "":
+			// Without a healthy copy there is nothing to say yes to.
+			fmt.Print("Cannot tell which copy is healthy. Repair from which copy? (A/B, empty to skip): ")
+			continue
+		case answer != "Y":
+			return
+		}
+		break
+	}
+
+	n, err := dump.Repair(source)
+	if err != nil {
+		fail(reader, "❌ Error repairing config copies:", err)
+	}
+	fmt.Printf("✅ Repaired %d field(s)\n", n)
+}
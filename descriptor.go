@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"change_sn/vcu"
+)
+
+// Descriptor states, from the bootloader's point of view.
+const (
+	descriptorErased  = "erased"
+	descriptorInvalid = "invalid"
+	descriptorIdle    = "idle"
+	descriptorPending = "pending"
+)
+
+type stagingReport struct {
+	Length      int    `json:"length" yaml:"length"`
+	Firmware    string `json:"firmware" yaml:"firmware"`
+	SHA256      string `json:"sha256" yaml:"sha256"`
+	Installable bool   `json:"installable" yaml:"installable"`
+	Problem     string `json:"problem,omitempty" yaml:"problem,omitempty"`
+}
+
+type descriptorReport struct {
+	File    string `json:"file" yaml:"file"`
+	Address string `json:"address" yaml:"address"`
+	Raw     string `json:"raw" yaml:"raw"`
+	State   string `json:"state" yaml:"state"`
+	Magic   uint32 `json:"magic" yaml:"magic"`
+	Pending uint32 `json:"pending" yaml:"pending"`
+	Length  uint32 `json:"length" yaml:"length"`
+	// Verified is set when the dump starts with the bootloader the
+	// descriptor layout was read from.
+	Verified bool           `json:"verified" yaml:"verified"`
+	Problem  string         `json:"problem,omitempty" yaml:"problem,omitempty"`
+	Staging  *stagingReport `json:"staging,omitempty" yaml:"staging,omitempty"`
+
+	err error // why an armed descriptor would fail
+}
+
+func newDescriptorReport(file string, dump *vcu.Dump) descriptorReport {
+	desc := dump.Descriptor()
+	r := descriptorReport{
+		File:    file,
+		Address: fmt.Sprintf("0x%08X", vcu.FlashBase+vcu.DescriptorOffset),
+		Raw:     fmt.Sprintf("% X", desc.Bytes()),
+		Magic:   desc.Magic,
+		Pending: desc.Pending,
+		Length:  desc.Length,
+	}
+	if err := dump.CheckDescriptorFormat(); err != nil {
+		r.Problem = err.Error()
+	} else {
+		r.Verified = true
+	}
+	switch {
+	case dump.DescriptorErased():
+		r.State = descriptorErased
+	case !desc.Valid():
+		r.State = descriptorInvalid
+	case desc.Armed():
+		r.State = descriptorPending
+	default:
+		r.State = descriptorIdle
+	}
+	if dump.Partial() {
+		return r
+	}
+
+	// Check the staging area against the declared length when an update
+	// is pending, otherwise against what is there.
+	length := dump.StagedLength()
+	if desc.Armed() {
+		length = int(desc.Length)
+	}
+	fw := dump.StagedFirmware()
+	r.Staging = &stagingReport{
+		Length:   dump.StagedLength(),
+		Firmware: fw.Version,
+		SHA256:   fw.AppSHA256,
+	}
+	if err := dump.CheckStaging(length); err != nil {
+		r.Staging.Problem = err.Error()
+		if desc.Armed() {
+			r.err = err
+		}
+	} else {
+		r.Staging.Installable = true
+	}
+	return r
+}
+
+func writeDescriptorText(w io.Writer, r descriptorReport) {
+	_, _ = fmt.Fprintf(w, "📍 Update descriptor at %s: %s\n", r.Address, r.Raw)
+	switch r.State {
+	case descriptorErased:
+		_, _ = fmt.Fprintln(w, "   Erased: the bootloader writes the default on the next boot")
+	case descriptorInvalid:
+		_, _ = fmt.Fprintf(w, "   Magic 0x%08X is not 0x%08X: the bootloader resets the descriptor on the next boot\n", r.Magic, vcu.DescriptorMagic)
+	case descriptorIdle:
+		_, _ = fmt.Fprintln(w, "✅ No update pending")
+	case descriptorPending:
+		_, _ = fmt.Fprintf(w, "⚠️ Update pending: %d bytes are copied to 0x%08X on the next boot\n", r.Length, vcu.FlashBase+vcu.AppOffset)
+		_, _ = fmt.Fprintln(w, "   The descriptor has no checksum: the staged image is installed as it is")
+	}
+	if !r.Verified {
+		_, _ = fmt.Fprintln(w, "⚠️", r.Problem)
+	}
+	s := r.Staging
+	if s == nil {
+		_, _ = fmt.Fprintln(w, "⚠️ Config-only dump: the staging area cannot be checked")
+		return
+	}
+	if s.Length == 0 {
+		_, _ = fmt.Fprintln(w, "📦 Staging area is erased")
+		if r.err != nil {
+			_, _ = fmt.Fprintln(w, "❌", s.Problem)
+		}
+		return
+	}
+	fw := s.Firmware
+	if fw == "" {
+		fw = "unknown"
+	}
+	_, _ = fmt.Fprintf(w, "📦 Staged image: %d bytes, firmware %s\n", s.Length, fw)
+	_, _ = fmt.Fprintln(w, "   SHA-256:", s.SHA256)
+	switch {
+	case s.Installable:
+		_, _ = fmt.Fprintln(w, "✅ Staged image can be installed")
+	case r.err != nil:
+		_, _ = fmt.Fprintln(w, "❌", s.Problem)
+	default:
+		_, _ = fmt.Fprintln(w, "⚠️", s.Problem)
+	}
+}
+
+// runDescriptor decodes the update descriptor, or arms or clears it. An
+// armed descriptor whose staged image the bootloader would not install is
+// an error, since the VCU may not boot. The descriptor is only written in
+// dumps with the bootloader its layout was read from, unless forced.
+func runDescriptor(args []string) error {
+	flags := newFlagSet("descriptor")
+	in := flags.String("in", "", "dump file to inspect or patch")
+	format := flags.String("format", formatText, "output format: text, json or yaml")
+	arm := flags.Bool("arm", false, "install the staged image on the next boot")
+	clearUpdate := flags.Bool("clear", false, "cancel a pending update")
+	length := flags.Int("length", 0, "bytes to install with --arm (default: the staged image)")
+	force := flags.Bool("force", false, "arm or clear even if the bootloader is not the stock one or the staged image fails the checks")
+	output := addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	switch {
+	case *arm && *clearUpdate:
+		return usageErrorf("--arm and --clear are mutually exclusive")
+	case *length < 0 || *length > vcu.AppSize:
+		return usageErrorf("--length must be 0–%d", vcu.AppSize)
+	case *format != formatText && *format != formatJSON && *format != formatYAML:
+		return usageErrorf("unknown format %q (want text, json or yaml)", *format)
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+	original := dump.Clone()
+	// The report below repeats the problem when forced.
+	if formatErr := dump.CheckDescriptorFormat(); formatErr != nil && (*arm || *clearUpdate) && !*force {
+		return fmt.Errorf("%w (use --force to write it anyway)", formatErr)
+	}
+	switch {
+	case *arm && *force:
+		n := *length
+		if n == 0 {
+			n = dump.StagedLength()
+		}
+		if n == 0 {
+			return usageErrorf("nothing staged: --arm --force needs --length")
+		}
+		dump.SetDescriptor(vcu.Descriptor{Magic: vcu.DescriptorMagic, Pending: 1, Length: uint32(n)})
+	case *arm:
+		err = dump.ArmUpdate(*length)
+	case *clearUpdate && *force:
+		dump.SetDescriptor(vcu.Descriptor{Magic: vcu.DescriptorMagic})
+	case *clearUpdate:
+		err = dump.ClearUpdate()
+	}
+	if err != nil {
+		return err
+	}
+
+	report := newDescriptorReport(*in, dump)
+	if *format == formatText {
+		writeDescriptorText(os.Stdout, report)
+	} else if err = writeValue(os.Stdout, *format, report); err != nil {
+		return err
+	}
+	if !*arm && !*clearUpdate {
+		return report.err
+	}
+	return writeDump(output.path(*in), original, dump, saveOptions{
+		in:        *in,
+		backup:    true,
+		overwrite: *output.overwrite,
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"change_sn/gdbrsp"
+	"change_sn/openocd"
+	"change_sn/stm32boot"
+	"change_sn/vcu"
+)
+
+// target is an open connection to a VCU.
+type target interface {
+	vcu.Target
+	// Reset restarts the VCU into its firmware.
+	Reset() error
+	Close() error
+}
+
+// targetFlags selects how to reach the VCU.
+type targetFlags struct {
+	openocd   *string
+	gdb       *string
+	gdbAttach *bool
+	uart      *string
+	baud      *int
+}
+
+func addTargetFlags(flags *flag.FlagSet) targetFlags {
+	return targetFlags{
+		openocd:   flags.String("openocd", "", "OpenOCD TCL-RPC address, e.g. "+openocd.DefaultAddr),
+		gdb:       flags.String("gdb", "", "gdbserver address, e.g. "+gdbrsp.DefaultAddr+" for st-util"),
+		gdbAttach: flags.Bool("gdb-attach", false, "scan and attach first, as a Black Magic Probe needs"),
+		uart:      flags.String("uart", "", "serial port of the STM32 ROM bootloader (BOOT0 high), e.g. /dev/ttyUSB0"),
+		baud:      flags.Int("baud", stm32boot.DefaultBaud, "baud rate for --uart"),
+	}
+}
+
+// open connects to the selected target and halts it.
+func (t targetFlags) open() (target, error) {
+	selected := 0
+	for _, s := range []string{*t.openocd, *t.gdb, *t.uart} {
+		if s != "" {
+			selected++
+		}
+	}
+	switch {
+	case selected > 1:
+		return nil, usageErrorf("--openocd, --gdb and --uart are mutually exclusive")
+	case *t.gdb != "":
+		return t.openGDB()
+	case *t.uart != "":
+		return t.openUART()
+	case *t.openocd == "":
+		return nil, usageErrorf("missing --openocd, --gdb or --uart")
+	}
+	c, err := openocd.Dial(*t.openocd)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Halt(); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	fmt.Println("✅ Connected to OpenOCD at", *t.openocd, "and halted the target")
+	return c, nil
+}
+
+func (t targetFlags) openGDB() (target, error) {
+	c, err := gdbrsp.Dial(*t.gdb)
+	if err != nil {
+		return nil, err
+	}
+	if *t.gdbAttach {
+		err = c.Attach()
+	} else {
+		err = c.Halt()
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	fmt.Println("✅ Connected to gdbserver at", *t.gdb)
+	return c, nil
+}
+
+// openUART connects to the ROM bootloader and refuses to go on when the
+// flash is readout protected, since every read would fail.
+func (t targetFlags) openUART() (target, error) {
+	c, err := stm32boot.Open(*t.uart, *t.baud)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("✅ STM32 bootloader v%d.%d on %s\n", c.Version>>4, c.Version&0x0F, *t.uart)
+	if id, err := c.GetID(); err == nil {
+		fmt.Printf("   Chip ID: 0x%03X\n", id)
+	}
+	protected, err := c.ReadoutProtected()
+	if err == nil && protected {
+		err = fmt.Errorf("%w; removing it erases the whole flash", stm32boot.ErrProtected)
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	fmt.Println("✅ Readout protection is off")
+	return c, nil
+}
+
+// runRead reads the flash of a connected VCU into a dump file.
+func runRead(args []string) error {
+	flags := newFlagSet("read")
+	dev := addTargetFlags(flags)
+	configOnly := flags.Bool("config-only", false, "read only the config sector 0x1F000–0x1FFFF")
+	out := flags.String("out", "", "dump file, .bin, .hex or .s19 (default: MEMORY_G3.bin, numbered if taken)")
+	overwrite := flags.Bool("overwrite", false, "replace an existing output file")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = nextFreeName("MEMORY_G3.bin")
+	}
+
+	t, err := dev.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = t.Close()
+	}()
+
+	start := time.Now()
+	dump, err := vcu.ReadTarget(t, *configOnly)
+	if err != nil {
+		return err
+	}
+	if err = writeImage(*out, dump, *overwrite); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Read %d bytes in %s\n", len(dump.Bytes()), time.Since(start).Round(time.Millisecond))
+	fmt.Println("✅ Dump written to:", *out)
+	if err = dump.Validate(); err != nil {
+		fmt.Printf("⚠️ %v: the flash may be damaged, restore it with flash --in <good dump>\n", err)
+	}
+	return nil
+}
+
+// runFlash writes a dump to a connected VCU. Only pages that differ from
+// the flash are programmed, and each one is read back. The flash content
+// is saved first so the write can be reverted.
+func runFlash(args []string) error {
+	flags := newFlagSet("flash")
+	in := flags.String("in", "", "dump file to write")
+	dev := addTargetFlags(flags)
+	reset := flags.Bool("reset", false, "reset and run the target when done")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+
+	t, err := dev.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = t.Close()
+	}()
+
+	current, err := vcu.ReadTarget(t, dump.Partial())
+	if err != nil {
+		return err
+	}
+	if len(vcu.ChangedPages(current, dump)) == 0 {
+		fmt.Println("✅ Flash already matches", *in)
+		return nil
+	}
+	backup := nextFreeName(fmt.Sprintf("device.%s.bak", time.Now().Format("20060102-150405")))
+	if err = writeImage(backup, current, false); err != nil {
+		return err
+	}
+	fmt.Println("💾 Flash content backed up to:", backup)
+
+	pages, err := vcu.WriteTarget(t, current, dump)
+	for _, p := range pages {
+		fmt.Printf("✅ Page 0x%08X written and verified\n", vcu.FlashBase+p)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ %d page(s) written from %s\n", len(pages), *in)
+
+	if *reset {
+		return t.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"change_sn/vcu"
+)
+
+// maxDiffRuns and maxRunBytes bound the text output for regions that
+// changed wholesale, such as the application after a firmware swap.
+const (
+	maxDiffRuns = 8
+	maxRunBytes = 16
+)
+
+type fieldChangeReport struct {
+	Field  string `json:"field" yaml:"field"`
+	Offset int    `json:"offset" yaml:"offset"`
+	Old    string `json:"old" yaml:"old"`
+	New    string `json:"new" yaml:"new"`
+}
+
+type byteRunReport struct {
+	Offset int    `json:"offset" yaml:"offset"`
+	Old    string `json:"old" yaml:"old"`
+	New    string `json:"new" yaml:"new"`
+}
+
+type regionDiffReport struct {
+	Region  string              `json:"region" yaml:"region"`
+	Start   int                 `json:"start" yaml:"start"`
+	End     int                 `json:"end" yaml:"end"`
+	Changed int                 `json:"changed_bytes" yaml:"changed_bytes"`
+	Fields  []fieldChangeReport `json:"fields" yaml:"fields"`
+	Runs    []byteRunReport     `json:"runs" yaml:"runs"`
+}
+
+func runDiff(args []string) error {
+	flags := newFlagSet("diff")
+	format := flags.String("format", formatText, "output format: text, json or yaml")
+	all := flags.Bool("all", false, "list every changed byte run in text output")
+	files, err := parseFlagsArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 2 {
+		return usageErrorf("diff needs exactly two dump files")
+	}
+
+	a, err := loadDump(files[0])
+	if err != nil {
+		return err
+	}
+	b, err := loadDump(files[1])
+	if err != nil {
+		return err
+	}
+
+	diffs := vcu.Diff(a, b)
+	switch *format {
+	case formatJSON, formatYAML:
+		return writeValue(os.Stdout, *format, newDiffReports(diffs))
+	case formatText:
+		writeDiffText(os.Stdout, diffs, *all)
+		return nil
+	}
+	return usageErrorf("unknown format %q (want text, json or yaml)", *format)
+}
+
+func newDiffReports(diffs []vcu.RegionDiff) []regionDiffReport {
+	reports := make([]regionDiffReport, 0, len(diffs))
+	for _, d := range diffs {
+		r := regionDiffReport{
+			Region:  d.Region.Name,
+			Start:   d.Region.Start,
+			End:     d.Region.End,
+			Changed: d.Changed,
+			Fields:  []fieldChangeReport{},
+			Runs:    []byteRunReport{},
+		}
+		for _, f := range d.Fields {
+			r.Fields = append(r.Fields, fieldChangeReport{f.Field.Name, f.Field.Offset, f.Old, f.New})
+		}
+		for _, run := range d.Runs {
+			r.Runs = append(r.Runs, byteRunReport{run.Offset, hex.EncodeToString(run.Old), hex.EncodeToString(run.New)})
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func writeDiffText(w io.Writer, diffs []vcu.RegionDiff, all bool) {
+	if len(diffs) == 0 {
+		_, _ = fmt.Fprintln(w, "✅ Dumps are identical")
+		return
+	}
+
+	for _, d := range diffs {
+		_, _ = fmt.Fprintf(w, "\n📍 %s (0x%05X–0x%05X): %d byte(s) changed\n",
+			d.Region.Name, d.Region.Start, d.Region.End-1, d.Changed)
+		for _, f := range d.Fields {
+			_, _ = fmt.Fprintf(w, "   %-12s 0x%05X: %s -> %s\n", f.Field.Name, f.Field.Offset, f.Old, f.New)
+		}
+		for i, run := range d.Runs {
+			if !all && i == maxDiffRuns {
+				_, _ = fmt.Fprintf(w, "   ... %d more run(s), use --all to list them\n", len(d.Runs)-i)
+				break
+			}
+			_, _ = fmt.Fprintf(w, "   %-12s 0x%05X: %s -> %s\n",
+				fmt.Sprintf("%d byte(s)", len(run.Old)), run.Offset, shortHex(run.Old), shortHex(run.New))
+		}
+	}
+}
+
+func shortHex(b []byte) string {
+	if len(b) > maxRunBytes {
+		return strings.ToUpper(hex.EncodeToString(b[:maxRunBytes])) + "…"
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
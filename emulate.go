@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"change_sn/ninebot"
+	"change_sn/vcu"
+	"github.com/creack/pty"
+	"go.bug.st/serial"
+	"golang.org/x/term"
+)
+
+var errReadOnly = errors.New("register is read-only")
+
+// registerFileSize covers every register index a frame can address.
+const registerFileSize = 0x100 * 2
+
+// emulatedField is a register range backed by the dump. Writes to it are
+// stored in the dump; every other register reads as zero and rejects
+// writes.
+type emulatedField struct {
+	name  string
+	index byte
+	size  int
+}
+
+// dumpRegisters serves the registers of a VCU from a dump and saves
+// register writes back into the dump file. The file is backed up before
+// the first write.
+type dumpRegisters struct {
+	path   string
+	dump   *vcu.Dump
+	backup string
+}
+
+// fields returns the emulated fields. The serial is as long as the layout
+// of the dump says.
+func (d *dumpRegisters) fields() []emulatedField {
+	return []emulatedField{
+		{"serial", ninebot.RegSerial, d.dump.Layout().SerialLength},
+		{"firmware", ninebot.RegFirmware, 2},
+		{"mileage", ninebot.RegTotalMileage, 4},
+		{"speed", ninebot.RegSpeedLimit, 2},
+	}
+}
+
+// registers builds the register file from the dump, using the same fields
+// the interactive editor shows.
+func (d *dumpRegisters) registers() []byte {
+	regs := make([]byte, registerFileSize)
+	if serials := d.dump.Serials(); len(serials) > 0 {
+		copy(regs[ninebot.RegSerial*2:], serials[0].Value)
+	}
+	if !d.dump.Partial() {
+		binary.LittleEndian.PutUint16(regs[ninebot.RegFirmware*2:], firmwareRegister(d.dump.DetectFirmware().Version))
+	}
+	a, b := d.dump.Mileage()
+	binary.LittleEndian.PutUint32(regs[ninebot.RegTotalMileage*2:], uint32(max(a, b))*100)
+	if speeds := d.dump.Speeds(); len(speeds) > 0 {
+		binary.LittleEndian.PutUint16(regs[ninebot.RegSpeedLimit*2:], uint16(speeds[0])*10)
+	}
+	return regs
+}
+
+// firmwareRegister encodes "1.5.6" as 0x0156, or 0 for an unknown version.
+func firmwareRegister(version string) uint16 {
+	var major, minor, patch uint16
+	if _, err := fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch); err != nil {
+		return 0
+	}
+	return major<<8 | minor<<4 | patch
+}
+
+func (d *dumpRegisters) ReadRegisters(index byte, n int) ([]byte, error) {
+	start := int(index) * 2
+	if start+n > registerFileSize {
+		return nil, fmt.Errorf("%w: %02X+%d", vcu.ErrOffset, index, n)
+	}
+	return d.registers()[start : start+n], nil
+}
+
+// WriteRegisters applies a write to a copy of the dump, so a rejected
+// value leaves it untouched, then saves it.
+func (d *dumpRegisters) WriteRegisters(index byte, data []byte) error {
+	start, end := int(index)*2, int(index)*2+len(data)
+	if end > registerFileSize {
+		return fmt.Errorf("%w: %02X+%d", vcu.ErrOffset, index, len(data))
+	}
+	regs := d.registers()
+	copy(regs[start:end], data)
+
+	dump := d.dump.Clone()
+	covered := 0
+	for _, f := range d.fields() {
+		fStart, fEnd := int(f.index)*2, int(f.index)*2+f.size
+		if fEnd <= start || fStart >= end {
+			continue
+		}
+		covered += min(end, fEnd) - max(start, fStart)
+		value := regs[fStart:fEnd]
+		var err error
+		switch f.index {
+		case ninebot.RegSerial:
+			_, err = dump.SetSerial(string(value))
+		case ninebot.RegTotalMileage:
+			err = dump.SetMileage(int(binary.LittleEndian.Uint32(value) / 100))
+		case ninebot.RegSpeedLimit:
+			err = dump.SetSpeed(int(binary.LittleEndian.Uint16(value)) / 10)
+		default:
+			err = errReadOnly
+		}
+		if err != nil {
+			fmt.Printf("❌ Write of %s rejected: %v\n", f.name, err)
+			return err
+		}
+		fmt.Printf("📝 %s set to %s\n", f.name, formatRegister(f, value))
+	}
+	if covered != len(data) {
+		fmt.Printf("❌ Write to register 0x%02X rejected: %v\n", index, errReadOnly)
+		return errReadOnly
+	}
+	return d.save(dump)
+}
+
+func formatRegister(f emulatedField, value []byte) string {
+	switch f.index {
+	case ninebot.RegSerial:
+		return string(bytes.TrimRight(value, "\x00"))
+	case ninebot.RegTotalMileage:
+		v := binary.LittleEndian.Uint32(value)
+		return fmt.Sprintf("%d (%.1f km)", v/100, float64(v)/1000.0)
+	}
+	v := binary.LittleEndian.Uint16(value) / 10
+	return fmt.Sprintf("%d (0x%02X)", v, v)
+}
+
+func (d *dumpRegisters) save(dump *vcu.Dump) error {
+	if d.backup == "" {
+		backup, err := backupFile(d.path)
+		if err != nil {
+			return err
+		}
+		d.backup = backup
+		fmt.Println("💾 Backup created:", backup)
+	}
+	if err := writeImage(d.path, dump, true); err != nil {
+		return err
+	}
+	d.dump = dump
+	fmt.Println("✅ Saved to:", d.path)
+	return nil
+}
+
+// openRawPty opens a pseudo-terminal whose terminal side is in raw mode,
+// so bus bytes such as 0x03, 0x0D and 0x11 pass through unchanged instead
+// of being taken as signals, line endings or flow control. The terminal
+// side stays open so clients can come and go.
+func openRawPty() (master, tty *os.File, err error) {
+	master, tty, err = pty.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = term.MakeRaw(int(tty.Fd())); err != nil {
+		_ = master.Close()
+		_ = tty.Close()
+		return nil, nil, fmt.Errorf("raw mode on %s: %w", tty.Name(), err)
+	}
+	return master, tty, nil
+}
+
+// runEmulate answers Ninebot bus requests from a dump, so dashboards and
+// BLE boards can be tested without a scooter.
+func runEmulate(args []string) error {
+	flags := newFlagSet("emulate")
+	dumpFile := flags.String("dump", "", "dump file to serve; register writes are saved into it")
+	usePty := flags.Bool("pty", false, "answer on a new pseudo-terminal")
+	port := flags.String("port", "", "answer on a serial port instead, e.g. /dev/ttyUSB0")
+	baud := flags.Int("baud", ninebot.DefaultBaud, "baud rate for --port")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	switch {
+	case *usePty && *port != "":
+		return usageErrorf("--pty and --port are mutually exclusive")
+	case !*usePty && *port == "":
+		return usageErrorf("missing --pty or --port")
+	}
+
+	dump, err := loadDump(*dumpFile)
+	if err != nil {
+		return err
+	}
+	regs := &dumpRegisters{path: *dumpFile, dump: dump}
+
+	var rw io.ReadWriteCloser
+	name := *port
+	if *usePty {
+		master, tty, err := openRawPty()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tty.Close()
+		}()
+		rw, name = master, tty.Name()
+	} else {
+		rw, err = serial.Open(*port, &serial.Mode{BaudRate: *baud, DataBits: 8, StopBits: serial.OneStopBit})
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		_ = rw.Close()
+	}()
+
+	fmt.Printf("✅ Emulating the VCU of %s on %s\n", *dumpFile, name)
+	live, _ := regs.ReadRegisters(0, registerFileSize)
+	writeLiveText(os.Stdout, &liveInfo{
+		Port:     name,
+		Serial:   string(bytes.TrimRight(live[ninebot.RegSerial*2:ninebot.RegSerial*2+dump.Layout().SerialLength], "\x00")),
+		Firmware: ninebot.FirmwareVersion(binary.LittleEndian.Uint16(live[ninebot.RegFirmware*2:])),
+		Mileage:  binary.LittleEndian.Uint32(live[ninebot.RegTotalMileage*2:]) / 100,
+		Speed:    int(binary.LittleEndian.Uint16(live[ninebot.RegSpeedLimit*2:])) / 10,
+	})
+	fmt.Println("\nPress Ctrl+C to stop")
+	return ninebot.Serve(rw, ninebot.AddrESC, regs)
+}
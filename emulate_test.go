@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"change_sn/ninebot"
+	"change_sn/vcu"
+)
+
+const testSerial = "1CGCC1234C1234"
+
+// writeTestDump writes a full dump with two serial copies, a mileage of
+// 1.6 km and a speed limit of 25 km/h, and returns its path.
+func writeTestDump(t *testing.T) string {
+	t.Helper()
+	b := bytes.Repeat([]byte{0xFF}, vcu.DumpSize)
+	copy(b, vcu.BootHeader())
+	copy(b[0x1F000:], testSerial)
+	copy(b[0x1F400:], testSerial)
+	for _, o := range vcu.DefaultLayout.Speeds {
+		b[o] = 25
+	}
+	binary.LittleEndian.PutUint16(b[vcu.DefaultLayout.MileageA:], 16)
+	binary.LittleEndian.PutUint16(b[vcu.DefaultLayout.MileageB:], 16)
+
+	path := filepath.Join(t.TempDir(), "vcu.bin")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestRegisters(t *testing.T) *dumpRegisters {
+	t.Helper()
+	path := writeTestDump(t)
+	dump, err := loadDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dumpRegisters{path: path, dump: dump}
+}
+
+// backups returns the backup files next to path.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestDumpRegistersRead(t *testing.T) {
+	regs := newTestRegisters(t)
+	for _, tt := range []struct {
+		name  string
+		index byte
+		want  []byte
+	}{
+		{"serial", ninebot.RegSerial, []byte(testSerial)},
+		{"mileage", ninebot.RegTotalMileage, binary.LittleEndian.AppendUint32(nil, 1600)},
+		{"speed", ninebot.RegSpeedLimit, binary.LittleEndian.AppendUint16(nil, 250)},
+		{"unmapped", 0x30, []byte{0, 0}},
+	} {
+		got, err := regs.ReadRegisters(tt.index, len(tt.want))
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: % X, %v, want % X", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := regs.ReadRegisters(0xFF, 4); !errors.Is(err, vcu.ErrOffset) {
+		t.Errorf("read past the register file: %v, want ErrOffset", err)
+	}
+}
+
+func TestDumpRegistersWrite(t *testing.T) {
+	regs := newTestRegisters(t)
+	original, err := os.ReadFile(regs.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = regs.WriteRegisters(ninebot.RegSerial, []byte("1CGCC9999C9999")); err != nil {
+		t.Fatal(err)
+	}
+	if err = regs.WriteRegisters(ninebot.RegSpeedLimit, binary.LittleEndian.AppendUint16(nil, 200)); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := vcu.Load(regs.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serials := saved.Serials(); len(serials) != 2 || serials[0].Value != "1CGCC9999C9999" {
+		t.Errorf("saved serials %v", serials)
+	}
+	if speeds := saved.Speeds(); speeds[0] != 20 {
+		t.Errorf("saved speed %d, want 20", speeds[0])
+	}
+
+	// One backup, of the file as it was before the first write.
+	names := backups(t, regs.path)
+	if len(names) != 1 || names[0] != regs.backup {
+		t.Fatalf("backups %v, want only %s", names, regs.backup)
+	}
+	if backup, _ := os.ReadFile(names[0]); !bytes.Equal(backup, original) {
+		t.Error("backup does not hold the original dump")
+	}
+}
+
+func TestDumpRegistersRejectsWrites(t *testing.T) {
+	regs := newTestRegisters(t)
+	original, err := os.ReadFile(regs.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		index byte
+		data  []byte
+		want  error
+	}{
+		{"firmware", ninebot.RegFirmware, []byte{0x56, 0x01}, errReadOnly},
+		{"unmapped", 0x30, []byte{0x01, 0x00}, errReadOnly},
+		{"serial and the register after it", ninebot.RegSerial, []byte(testSerial + "XX"), errReadOnly},
+		{"bad serial", ninebot.RegSerial, []byte("XXXXXXXXXXXXXX"), nil},
+	} {
+		err := regs.WriteRegisters(tt.index, tt.data)
+		if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if got, _ := os.ReadFile(regs.path); !bytes.Equal(got, original) {
+		t.Error("rejected writes changed the dump file")
+	}
+	if names := backups(t, regs.path); len(names) != 0 {
+		t.Errorf("rejected writes created backups %v", names)
+	}
+}
+
+func TestEmulateOverPty(t *testing.T) {
+	regs := newTestRegisters(t)
+	master, tty, err := openRawPty()
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = tty.Close()
+	})
+	go func() {
+		_ = ninebot.Serve(master, ninebot.AddrESC, regs)
+	}()
+
+	c := ninebot.NewClient(tty)
+	got, err := c.ReadRegister(ninebot.AddrESC, ninebot.RegSerial, len(testSerial))
+	if err != nil || string(got) != testSerial {
+		t.Fatalf("serial %q, %v, want %q", got, err, testSerial)
+	}
+	// The value is sent as 0A 0D 00 00, which passes the terminal
+	// unchanged only in raw mode.
+	if err = c.WriteRegister(ninebot.AddrESC, ninebot.RegTotalMileage, binary.LittleEndian.AppendUint32(nil, 0x0D0A)); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := vcu.Load(regs.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := saved.Mileage(); a != 0x0D0A/100 {
+		t.Errorf("saved mileage %d, want %d", a, 0x0D0A/100)
+	}
+}
+
+func TestDumpRegistersSerialLength(t *testing.T) {
+	regs := newTestRegisters(t)
+	short := *regs.dump.Layout()
+	short.SerialLength = 12
+	regs.dump.SetLayout(&short)
+
+	if got, err := regs.ReadRegisters(ninebot.RegSerial, 14); err != nil || string(got) != testSerial[:12]+"\x00\x00" {
+		t.Errorf("serial registers %q, %v, want the 12-character serial", got, err)
+	}
+	if err := regs.WriteRegisters(ninebot.RegSerial, []byte("1CGCC9999C99")); err != nil {
+		t.Fatal(err)
+	}
+	if err := regs.WriteRegisters(ninebot.RegSerial, []byte(testSerial)); !errors.Is(err, errReadOnly) {
+		t.Errorf("14-character write: %v, want errReadOnly", err)
+	}
+}
@@ -0,0 +1,311 @@
+// Package gdbrsp is a minimal GDB remote serial protocol client for
+// reading and programming the VCU flash through a gdbserver such as
+// st-util or a Black Magic Probe.
+//
+// Memory is read with "m" packets and flash is programmed with the
+// vFlashErase, vFlashWrite and vFlashDone packets, so the gdbserver takes
+// care of the flash algorithm.
+package gdbrsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"change_sn/vcu"
+)
+
+// DefaultAddr is where st-util listens by default.
+const DefaultAddr = "localhost:4242"
+
+// defaultPacketSize is used when the server does not announce one.
+const defaultPacketSize = 0x400
+
+var ErrProtocol = errors.New("gdbrsp: unexpected response")
+
+// ReplyError is an "Exx" error reply from the server.
+type ReplyError struct {
+	Packet string
+	Code   string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("gdbrsp: %s: error %s", e.Packet, e.Code)
+}
+
+// Client is a connection to a gdbserver. It implements vcu.Target.
+type Client struct {
+	conn       io.ReadWriteCloser
+	r          *bufio.Reader
+	packetSize int
+	Timeout    time.Duration
+}
+
+// Dial connects to the gdbserver at addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient starts a session over conn, which may be a serial port for
+// probes that expose the gdbserver over USB CDC.
+func NewClient(conn io.ReadWriteCloser) (*Client, error) {
+	c := &Client{conn: conn, r: bufio.NewReader(conn), packetSize: defaultPacketSize, Timeout: 30 * time.Second}
+	resp, err := c.Request("qSupported:swbreak+;hwbreak+")
+	if err != nil {
+		return nil, err
+	}
+	for _, feature := range strings.Split(resp, ";") {
+		if v, ok := strings.CutPrefix(feature, "PacketSize="); ok {
+			if n, err := strconv.ParseInt(v, 16, 32); err == nil && n >= 64 {
+				c.packetSize = int(n)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Close detaches, which lets the target run, and closes the connection.
+func (c *Client) Close() error {
+	_, _ = c.Request("D")
+	return c.conn.Close()
+}
+
+func (c *Client) setDeadline() {
+	if d, ok := c.conn.(interface{ SetDeadline(time.Time) error }); ok && c.Timeout > 0 {
+		_ = d.SetDeadline(time.Now().Add(c.Timeout))
+	}
+}
+
+// Request sends a packet and returns the reply. "Exx" replies are
+// returned as a *ReplyError.
+func (c *Client) Request(packet string) (string, error) {
+	c.setDeadline()
+	for attempt := 0; ; attempt++ {
+		if err := c.send(packet); err != nil {
+			return "", err
+		}
+		ack, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if ack == '+' {
+			break
+		}
+		if attempt == 3 {
+			return "", fmt.Errorf("%w: %s: not acknowledged", ErrProtocol, shorten(packet))
+		}
+	}
+
+	reply, err := c.receive()
+	if err != nil {
+		return "", err
+	}
+	if len(reply) == 3 && reply[0] == 'E' {
+		return "", &ReplyError{Packet: shorten(packet), Code: reply[1:]}
+	}
+	return reply, nil
+}
+
+func (c *Client) send(packet string) error {
+	var sum byte
+	for i := 0; i < len(packet); i++ {
+		sum += packet[i]
+	}
+	_, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, sum)
+	return err
+}
+
+// receive reads one packet, skipping stray acks and console output, and
+// acknowledges it.
+func (c *Client) receive() (string, error) {
+	for {
+		if _, err := c.r.ReadString('$'); err != nil {
+			return "", err
+		}
+		body, err := c.r.ReadString('#')
+		if err != nil {
+			return "", err
+		}
+		body = body[:len(body)-1]
+		cs := make([]byte, 2)
+		if _, err = io.ReadFull(c.r, cs); err != nil {
+			return "", err
+		}
+
+		var sum byte
+		for i := 0; i < len(body); i++ {
+			sum += body[i]
+		}
+		if want, err := strconv.ParseUint(string(cs), 16, 8); err != nil || byte(want) != sum {
+			if _, err = c.conn.Write([]byte{'-'}); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if _, err = c.conn.Write([]byte{'+'}); err != nil {
+			return "", err
+		}
+
+		reply := expandRunLength(body)
+		// "O" packets carry console output for monitor commands.
+		if len(reply) > 1 && reply[0] == 'O' && reply != "OK" {
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// expandRunLength undoes the "x*n" run-length encoding servers may use in
+// replies.
+func expandRunLength(s string) string {
+	if !strings.Contains(s, "*") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '*' && i > 0 && i+1 < len(s) {
+			prev := s[i-1]
+			b.WriteString(strings.Repeat(string(prev), int(s[i+1])-29))
+			i++
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// escapeBinary escapes the characters that cannot appear in binary data.
+func escapeBinary(data []byte) []byte {
+	var b bytes.Buffer
+	for _, v := range data {
+		switch v {
+		case '#', '$', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(v ^ 0x20)
+		default:
+			b.WriteByte(v)
+		}
+	}
+	return b.Bytes()
+}
+
+func shorten(packet string) string {
+	if i := strings.IndexAny(packet, ":,"); i > 0 {
+		return packet[:i]
+	}
+	return packet
+}
+
+// Monitor runs a server specific "monitor" command.
+func (c *Client) Monitor(cmd string) error {
+	resp, err := c.Request("qRcmd," + hex.EncodeToString([]byte(cmd)))
+	if err != nil {
+		return err
+	}
+	if resp != "OK" && resp != "" {
+		return fmt.Errorf("%w: monitor %s: %q", ErrProtocol, cmd, resp)
+	}
+	return nil
+}
+
+// Attach scans for targets and attaches to the first one. Black Magic
+// Probes need this before memory can be accessed; st-util attaches on
+// connect.
+func (c *Client) Attach() error {
+	if err := c.Monitor("swdp_scan"); err != nil {
+		return err
+	}
+	resp, err := c.Request("vAttach;1")
+	if err != nil {
+		return err
+	}
+	if resp == "" || resp[0] != 'T' && resp[0] != 'S' {
+		return fmt.Errorf("%w: vAttach: %q", ErrProtocol, resp)
+	}
+	return nil
+}
+
+// Halt checks that the target is stopped. Servers halt the target when a
+// debugger connects or attaches.
+func (c *Client) Halt() error {
+	resp, err := c.Request("?")
+	if err != nil {
+		return err
+	}
+	if resp == "" || resp[0] != 'T' && resp[0] != 'S' {
+		return fmt.Errorf("%w: target not stopped: %q", ErrProtocol, resp)
+	}
+	return nil
+}
+
+// Reset asks the server to reset the target. The target runs once the
+// client detaches.
+func (c *Client) Reset() error {
+	return c.Monitor("reset")
+}
+
+// ReadFlash reads n bytes of flash starting at offset.
+func (c *Client) ReadFlash(offset, n int) ([]byte, error) {
+	chunk := (c.packetSize - 8) / 2
+	data := make([]byte, 0, n)
+	for len(data) < n {
+		count := min(chunk, n-len(data))
+		addr := vcu.FlashBase + offset + len(data)
+		resp, err := c.Request(fmt.Sprintf("m%x,%x", addr, count))
+		if err != nil {
+			return nil, err
+		}
+		b, err := hex.DecodeString(resp)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("%w: m%x: bad reply of %d characters", ErrProtocol, addr, len(resp))
+		}
+		// Servers may return fewer bytes than asked for.
+		data = append(data, b[:min(len(b), count)]...)
+	}
+	return data, nil
+}
+
+// ProgramPage erases the flash page at offset and programs data into it.
+func (c *Client) ProgramPage(offset int, data []byte) error {
+	addr := vcu.FlashBase + offset
+	if err := c.expectOK(fmt.Sprintf("vFlashErase:%x,%x", addr, len(data))); err != nil {
+		return err
+	}
+	// Worst case every byte is escaped.
+	chunk := (c.packetSize - 32) / 2
+	for i := 0; i < len(data); i += chunk {
+		part := data[i:min(i+chunk, len(data))]
+		packet := fmt.Sprintf("vFlashWrite:%x:", addr+i) + string(escapeBinary(part))
+		if err := c.expectOK(packet); err != nil {
+			return err
+		}
+	}
+	return c.expectOK("vFlashDone")
+}
+
+func (c *Client) expectOK(packet string) error {
+	resp, err := c.Request(packet)
+	if err != nil {
+		return err
+	}
+	if resp != "OK" {
+		return fmt.Errorf("%w: %s: %q", ErrProtocol, shorten(packet), resp)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package gdbrsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"change_sn/vcu"
+)
+
+// stubServer is an in-process gdbserver with flash semantics: vFlashErase
+// erases whole pages, vFlashWrite may only program erased bytes and the
+// writes take effect at vFlashDone.
+type stubServer struct {
+	flash      []byte
+	packetSize int // announced in qSupported; 0 announces none
+	maxReply   int // most bytes returned per "m" packet
+	runLength  bool
+
+	pending  map[int]byte // written but not yet committed
+	packets  []string
+	erasures int
+}
+
+// start serves the stub on one end of a pipe and returns a client on the
+// other.
+func (s *stubServer) start(t *testing.T) *Client {
+	t.Helper()
+	server, conn := net.Pipe()
+	s.pending = map[int]byte{}
+	go s.serve(server)
+	c, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func (s *stubServer) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		if _, err := r.ReadString('$'); err != nil {
+			return
+		}
+		body, err := r.ReadString('#')
+		if err != nil {
+			return
+		}
+		if _, err = io.ReadFull(r, make([]byte, 2)); err != nil {
+			return
+		}
+		if _, err = conn.Write([]byte{'+'}); err != nil {
+			return
+		}
+		packet := body[:len(body)-1]
+		s.packets = append(s.packets, packet)
+		reply := s.handle(packet)
+		if s.runLength {
+			reply = encodeRunLength(reply)
+		}
+		var sum byte
+		for i := 0; i < len(reply); i++ {
+			sum += reply[i]
+		}
+		if _, err = fmt.Fprintf(conn, "$%s#%02x", reply, sum); err != nil {
+			return
+		}
+		// Wait for the client's ack.
+		if _, err = r.ReadByte(); err != nil {
+			return
+		}
+		if packet == "D" {
+			return
+		}
+	}
+}
+
+func (s *stubServer) handle(packet string) string {
+	hexArgs := func(args string) (int, int, bool) {
+		a, n, ok := strings.Cut(args, ",")
+		addr, err1 := strconv.ParseUint(a, 16, 32)
+		size, err2 := strconv.ParseUint(n, 16, 32)
+		return int(addr) - vcu.FlashBase, int(size), ok && err1 == nil && err2 == nil
+	}
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		if s.packetSize == 0 {
+			return "swbreak+"
+		}
+		return fmt.Sprintf("PacketSize=%x;swbreak+", s.packetSize)
+	case packet == "?":
+		return "S05"
+	case packet == "D":
+		return "OK"
+	case strings.HasPrefix(packet, "m"):
+		offset, n, ok := hexArgs(packet[1:])
+		if !ok || offset < 0 || offset >= len(s.flash) {
+			return "E01"
+		}
+		n = min(n, s.maxReply, len(s.flash)-offset)
+		return hex.EncodeToString(s.flash[offset : offset+n])
+	case strings.HasPrefix(packet, "vFlashErase:"):
+		offset, n, ok := hexArgs(packet[len("vFlashErase:"):])
+		if !ok || offset < 0 || offset%vcu.PageSize != 0 || n%vcu.PageSize != 0 || offset+n > len(s.flash) {
+			return "E02"
+		}
+		for i := offset; i < offset+n; i++ {
+			s.flash[i] = 0xFF
+		}
+		s.erasures++
+		return "OK"
+	case strings.HasPrefix(packet, "vFlashWrite:"):
+		a, data, _ := strings.Cut(packet[len("vFlashWrite:"):], ":")
+		addr, err := strconv.ParseUint(a, 16, 32)
+		if err != nil {
+			return "E03"
+		}
+		offset := int(addr) - vcu.FlashBase
+		for i, b := range unescapeBinary([]byte(data)) {
+			if s.flash[offset+i] != 0xFF {
+				return "E04" // not erased
+			}
+			s.pending[offset+i] = b
+		}
+		return "OK"
+	case packet == "vFlashDone":
+		for offset, b := range s.pending {
+			s.flash[offset] = b
+		}
+		clear(s.pending)
+		return "OK"
+	}
+	return ""
+}
+
+// encodeRunLength compresses runs like a server may. Run lengths that
+// would encode as '#' or '$' are split.
+func encodeRunLength(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		j := i
+		for j < len(s) && s[j] == s[i] && j-i < 97 {
+			j++
+		}
+		n := j - i - 1 // repeats after the first character
+		if n == 6 || n == 7 {
+			n = 5
+		}
+		b.WriteByte(s[i])
+		if n >= 3 {
+			b.WriteByte('*')
+			b.WriteByte(byte(n + 29))
+			i += n + 1
+			continue
+		}
+		i++
+	}
+	return b.String()
+}
+
+func unescapeBinary(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+func testFlash() []byte {
+	flash := bytes.Repeat([]byte{0xFF}, vcu.DumpSize)
+	for i := 0; i < len(flash); i += 3 {
+		flash[i] = byte(i)
+	}
+	return flash
+}
+
+func TestPacketSize(t *testing.T) {
+	for _, tt := range []struct {
+		announced, want int
+	}{
+		{0, defaultPacketSize},
+		{0x4000, 0x4000},
+		{0x20, defaultPacketSize}, // too small to be usable
+	} {
+		s := &stubServer{flash: testFlash(), packetSize: tt.announced, maxReply: 0x10000}
+		if c := s.start(t); c.packetSize != tt.want {
+			t.Errorf("PacketSize=%x: packet size %x, want %x", tt.announced, c.packetSize, tt.want)
+		}
+	}
+}
+
+func TestReadFlash(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		stub stubServer
+	}{
+		{"full replies", stubServer{packetSize: 0x400, maxReply: 0x10000}},
+		{"short replies", stubServer{packetSize: 0x400, maxReply: 100}},
+		{"run-length replies", stubServer{packetSize: 0x400, maxReply: 0x10000, runLength: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			flash := testFlash()
+			tt.stub.flash = bytes.Clone(flash)
+			c := tt.stub.start(t)
+			got, err := c.ReadFlash(0x1F000, 0x1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, flash[0x1F000:0x20000]) {
+				t.Error("ReadFlash returned the wrong bytes")
+			}
+		})
+	}
+}
+
+func TestProgramPage(t *testing.T) {
+	s := &stubServer{flash: testFlash(), packetSize: 0x100, maxReply: 0x10000}
+	c := s.start(t)
+
+	// Every byte that needs escaping, in a page that is not erased yet.
+	page := bytes.Repeat([]byte{'#', '$', '}', '*', 0x00, 0x7E}, vcu.PageSize/6+1)[:vcu.PageSize]
+	if err := c.ProgramPage(0x1F400, page); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.flash[0x1F400:0x1F400+vcu.PageSize], page) {
+		t.Error("page was not programmed")
+	}
+	if s.erasures != 1 {
+		t.Errorf("%d erasures, want 1", s.erasures)
+	}
+
+	var writes int
+	var last string
+	for _, p := range s.packets {
+		if strings.HasPrefix(p, "vFlashWrite:") {
+			writes++
+			if len(p)+4 > c.packetSize {
+				t.Errorf("vFlashWrite of %d bytes exceeds the packet size %d", len(p)+4, c.packetSize)
+			}
+		}
+		last = p
+	}
+	if writes < 2 {
+		t.Errorf("%d vFlashWrite packets, want the page split", writes)
+	}
+	if last != "vFlashDone" {
+		t.Errorf("last packet %q, want vFlashDone", last)
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	s := &stubServer{flash: testFlash(), packetSize: 0x400, maxReply: 0x10000}
+	c := s.start(t)
+
+	_, err := c.ReadFlash(vcu.DumpSize, 4)
+	var re *ReplyError
+	if !errors.As(err, &re) || re.Code != "01" || re.Packet != "m8020000" {
+		t.Errorf("read outside flash: %v, want ReplyError 01", err)
+	}
+	if err = c.ProgramPage(0x1F001, make([]byte, vcu.PageSize)); !errors.As(err, &re) || re.Packet != "vFlashErase" {
+		t.Errorf("unaligned erase: %v, want a vFlashErase ReplyError", err)
+	}
+}
+
+func TestEscapeBinary(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"abc", "abc"},
+		{"#", "}\x03"},
+		{"$", "}\x04"},
+		{"}", "}]"},
+		{"*", "}\x0a"},
+		{"a#b}", "a}\x03b}]"},
+	} {
+		if got := string(escapeBinary([]byte(tt.in))); got != tt.want {
+			t.Errorf("escapeBinary(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := string(unescapeBinary(escapeBinary([]byte(tt.in)))); got != tt.in {
+			t.Errorf("round trip of %q gave %q", tt.in, got)
+		}
+	}
+}
+
+func TestExpandRunLength(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"0123", "0123"},
+		{"0* ", "0000"},
+		{"ff*\"a", "fffffffa"},
+		{encodeRunLength(strings.Repeat("e", 300)), strings.Repeat("e", 300)},
+	} {
+		if got := expandRunLength(tt.in); got != tt.want {
+			t.Errorf("expandRunLength(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -3,7 +3,15 @@ module change_sn
 go 1.23.5
 
 require (
-	github.com/chzyer/readline v1.5.1 // indirect
-	github.com/common-nighthawk/go-figure v0.0.0-20210622060536-734e95fb86be // indirect
-	golang.org/x/sys v0.1.0 // indirect
+	github.com/chzyer/readline v1.5.1
+	github.com/common-nighthawk/go-figure v0.0.0-20210622060536-734e95fb86be
+	github.com/creack/pty v1.1.24
+	go.bug.st/serial v1.6.4
+	golang.org/x/term v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/creack/goselect v0.1.2 // indirect
+	golang.org/x/sys v0.19.0 // indirect
 )
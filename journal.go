@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+
+	"change_sn/vcu"
+)
+
+// saveOptions describes where a patched dump came from and how to store it.
+type saveOptions struct {
+	// in names the file the original was loaded from.
+	in string
+	// backup keeps a timestamped copy of the original before saving. It
+	// is off when the original is a firmware template.
+	backup bool
+	// overwrite allows replacing an existing output and journal.
+	overwrite bool
+	// keySource is the dump the secret key was copied from, if any.
+	keySource string
+}
+
+// saveDump backs up the original if requested, then atomically writes the
+// patched dump to out and a change journal next to it. It returns the
+// journal path.
+func saveDump(out string, original, dump *vcu.Dump, opts saveOptions) (string, error) {
+	journalPath := vcu.JournalPath(out)
+	if !opts.overwrite {
+		for _, p := range []string{out, journalPath} {
+			if exists(p) {
+				return "", &fs.PathError{Op: "write", Path: p, Err: errExists}
+			}
+		}
+	}
+
+	if opts.backup {
+		backup, err := backupFile(opts.in)
+		if err != nil {
+			return "", err
+		}
+		fmt.Println("💾 Original backed up to:", backup)
+	}
+
+	j := vcu.NewJournal(original, dump)
+	j.Input.Path = opts.in
+	j.Output.Path = out
+	j.KeySource = opts.keySource
+	j.User = currentUser()
+	j.Host, _ = os.Hostname()
+	data, err := j.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	if err = writeImage(out, dump, opts.overwrite); err != nil {
+		return "", err
+	}
+	return journalPath, writeFileAtomic(journalPath, data, opts.overwrite)
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
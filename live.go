@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"change_sn/ninebot"
+	"change_sn/vcu"
+)
+
+// errLiveMismatch is returned when a dump does not belong to the scooter
+// on the bus.
+var errLiveMismatch = errors.New("dump does not match the connected scooter")
+
+// liveInfo holds the values read from a running VCU, in dump units.
+type liveInfo struct {
+	Port     string `json:"port" yaml:"port"`
+	Serial   string `json:"serial" yaml:"serial"`
+	Firmware string `json:"firmware" yaml:"firmware"`
+	// Mileage is in units of 0.1 km like the dump; MileageMetres is the
+	// raw odometer.
+	Mileage       uint32 `json:"mileage" yaml:"mileage"`
+	MileageMetres uint32 `json:"mileage_metres" yaml:"mileage_metres"`
+	Speed         int    `json:"speed" yaml:"speed"`
+
+	Dump *liveComparison `json:"dump,omitempty" yaml:"dump,omitempty"`
+}
+
+// liveComparison checks a dump against the live values. The odometer only
+// grows, so a dump with a higher mileage cannot come from this scooter;
+// a lower one is just older. The speed limit can be changed from the app
+// and is only reported.
+type liveComparison struct {
+	File          string   `json:"file" yaml:"file"`
+	Serials       []string `json:"serials" yaml:"serials"`
+	SerialMatch   bool     `json:"serial_match" yaml:"serial_match"`
+	MileageA      uint16   `json:"mileage_a" yaml:"mileage_a"`
+	MileageB      uint16   `json:"mileage_b" yaml:"mileage_b"`
+	MileageMatch  string   `json:"mileage_match" yaml:"mileage_match"` // same, older or newer
+	Speeds        []int    `json:"speeds" yaml:"speeds"`
+	SpeedMatch    bool     `json:"speed_match" yaml:"speed_match"`
+	Firmware      string   `json:"firmware" yaml:"firmware"`
+	FirmwareMatch bool     `json:"firmware_match" yaml:"firmware_match"`
+}
+
+func (c *liveComparison) ok() bool {
+	return c.SerialMatch && c.MileageMatch != "newer"
+}
+
+func runLive(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("missing live command (want info or record)")
+	}
+	switch args[0] {
+	case "info":
+		return runLiveInfo(args[1:])
+	case "record":
+		return runLiveRecord(args[1:])
+	}
+	return usageErrorf("unknown live command %q (want info or record)", args[0])
+}
+
+// runLiveInfo reads the serial, mileage and speed limit from the VCU over
+// the scooter UART and optionally checks them against a dump.
+func runLiveInfo(args []string) error {
+	flags := newFlagSet("live")
+	port := flags.String("port", "", "serial port of the scooter bus, e.g. /dev/ttyUSB0")
+	baud := flags.Int("baud", ninebot.DefaultBaud, "baud rate")
+	dumpFile := flags.String("dump", "", "dump file to compare the live values with")
+	layoutName := flags.String("layout", "", "layout profile name or file for the serial length (default: the dump's)")
+	format := flags.String("format", formatText, "output format: text, json or yaml")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	switch {
+	case *port == "":
+		return usageErrorf("missing --port")
+	case *format != formatText && *format != formatJSON && *format != formatYAML:
+		return usageErrorf("unknown format %q (want text, json or yaml)", *format)
+	}
+
+	layout, err := resolveLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+	var dump *vcu.Dump
+	if *dumpFile != "" {
+		if dump, err = loadDump(*dumpFile); err != nil {
+			return err
+		}
+		if layout != nil {
+			dump.SetLayout(layout)
+		}
+		layout = dump.Layout()
+	}
+	if layout == nil {
+		layout = vcu.DefaultLayout
+	}
+
+	c, err := ninebot.Open(*port, *baud)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	info, err := readLiveInfo(c, layout)
+	if err != nil {
+		return err
+	}
+	info.Port = *port
+	if dump != nil {
+		info.Dump = compareLive(info, *dumpFile, dump)
+	}
+
+	if *format == formatText {
+		writeLiveText(os.Stdout, info)
+	} else if err = writeValue(os.Stdout, *format, info); err != nil {
+		return err
+	}
+	if info.Dump != nil && !info.Dump.ok() {
+		return fmt.Errorf("%s: %w", *dumpFile, errLiveMismatch)
+	}
+	return nil
+}
+
+// readLiveInfo reads the values of the info command. The serial is read
+// with the length of layout.
+func readLiveInfo(c *ninebot.Client, layout *vcu.Layout) (*liveInfo, error) {
+	sn, err := c.ReadRegister(ninebot.AddrESC, ninebot.RegSerial, layout.SerialLength)
+	if err != nil {
+		return nil, fmt.Errorf("reading serial: %w", err)
+	}
+	fw, err := c.ReadUint16(ninebot.AddrESC, ninebot.RegFirmware)
+	if err != nil {
+		return nil, fmt.Errorf("reading firmware version: %w", err)
+	}
+	metres, err := c.ReadUint32(ninebot.AddrESC, ninebot.RegTotalMileage)
+	if err != nil {
+		return nil, fmt.Errorf("reading mileage: %w", err)
+	}
+	speed, err := c.ReadUint16(ninebot.AddrESC, ninebot.RegSpeedLimit)
+	if err != nil {
+		return nil, fmt.Errorf("reading speed limit: %w", err)
+	}
+	return &liveInfo{
+		Serial:        string(bytes.TrimRight(sn, "\x00 ")),
+		Firmware:      ninebot.FirmwareVersion(fw),
+		Mileage:       metres / 100,
+		MileageMetres: metres,
+		Speed:         int(speed) / 10,
+	}, nil
+}
+
+func compareLive(info *liveInfo, file string, dump *vcu.Dump) *liveComparison {
+	c := &liveComparison{File: file, Serials: []string{}, Speeds: []int{}}
+	for _, sn := range dump.Serials() {
+		c.Serials = append(c.Serials, sn.Value)
+	}
+	c.SerialMatch = slices.Contains(c.Serials, info.Serial)
+
+	c.MileageA, c.MileageB = dump.Mileage()
+	switch newest := uint32(max(c.MileageA, c.MileageB)); {
+	case newest == info.Mileage:
+		c.MileageMatch = "same"
+	case newest < info.Mileage:
+		c.MileageMatch = "older"
+	default:
+		c.MileageMatch = "newer"
+	}
+
+	c.SpeedMatch = true
+	for _, s := range dump.Speeds() {
+		c.Speeds = append(c.Speeds, int(s))
+		c.SpeedMatch = c.SpeedMatch && int(s) == info.Speed
+	}
+
+	if !dump.Partial() {
+		c.Firmware = dump.DetectFirmware().Version
+	}
+	c.FirmwareMatch = c.Firmware == info.Firmware
+	return c
+}
+
+// writeLiveText prints the live values like the interactive editor prints
+// those of a dump.
+func writeLiveText(w io.Writer, info *liveInfo) {
+	_, _ = fmt.Fprintf(w, "✅ VCU on %s, firmware %s\n", info.Port, info.Firmware)
+	_, _ = fmt.Fprintln(w, "\nFound serial numbers:")
+	_, _ = fmt.Fprintf(w, "-> %s\n", info.Serial)
+	_, _ = fmt.Fprintf(w, "🚗 Current mileage: %d (%.1f km)\n", info.Mileage, float64(info.Mileage)/10.0)
+	_, _ = fmt.Fprintln(w, "🚀 Current speed values:")
+	_, _ = fmt.Fprintf(w, "-> %d (0x%02X)\n", info.Speed, info.Speed)
+
+	c := info.Dump
+	if c == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "\nCompared with %s:\n", c.File)
+	if c.SerialMatch {
+		_, _ = fmt.Fprintf(w, "✅ Serial %s found in the dump\n", info.Serial)
+	} else {
+		_, _ = fmt.Fprintf(w, "❌ Serial %s not in the dump, which has %v\n", info.Serial, c.Serials)
+	}
+	switch c.MileageMatch {
+	case "same":
+		_, _ = fmt.Fprintln(w, "✅ Mileage matches")
+	case "older":
+		_, _ = fmt.Fprintf(w, "⚠️ Dump mileage %d/%d is behind the odometer: the dump is older\n", c.MileageA, c.MileageB)
+	default:
+		_, _ = fmt.Fprintf(w, "❌ Dump mileage %d/%d is ahead of the odometer\n", c.MileageA, c.MileageB)
+	}
+	if c.SpeedMatch {
+		_, _ = fmt.Fprintln(w, "✅ Speed limit matches")
+	} else {
+		_, _ = fmt.Fprintf(w, "⚠️ Dump speed limits %v differ from the live limit\n", c.Speeds)
+	}
+	switch {
+	case c.Firmware == "":
+		_, _ = fmt.Fprintln(w, "⚠️ Firmware version of the dump unknown")
+	case c.FirmwareMatch:
+		_, _ = fmt.Fprintln(w, "✅ Firmware version matches")
+	default:
+		_, _ = fmt.Fprintf(w, "⚠️ Dump firmware %s differs from the live %s\n", c.Firmware, info.Firmware)
+	}
+	if c.ok() {
+		_, _ = fmt.Fprintln(w, "✅ The dump belongs to this scooter")
+	}
+}
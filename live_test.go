@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"change_sn/ninebot"
+)
+
+func TestReadLiveInfoUsesSerialLength(t *testing.T) {
+	regs := newTestRegisters(t)
+	short := *regs.dump.Layout()
+	short.SerialLength = 12
+	regs.dump.SetLayout(&short)
+
+	master, tty, err := openRawPty()
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = tty.Close()
+	})
+	go func() {
+		_ = ninebot.Serve(master, ninebot.AddrESC, regs)
+	}()
+
+	info, err := readLiveInfo(ninebot.NewClient(tty), &short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Serial != testSerial[:12] || info.Mileage != 16 || info.Speed != 25 {
+		t.Errorf("read %+v", info)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"change_sn/vcu"
+)
+
+type mapFieldReport struct {
+	Name   string `json:"name" yaml:"name"`
+	Offset int    `json:"offset" yaml:"offset"`
+	Length int    `json:"length" yaml:"length"`
+	Value  string `json:"value" yaml:"value"`
+}
+
+type regionReport struct {
+	Name        string           `json:"name" yaml:"name"`
+	Start       string           `json:"start" yaml:"start"`
+	End         string           `json:"end" yaml:"end"`
+	Size        int              `json:"size" yaml:"size"`
+	Erased      bool             `json:"erased" yaml:"erased"`
+	ErasedBytes int              `json:"erased_bytes" yaml:"erased_bytes"`
+	SHA256      string           `json:"sha256" yaml:"sha256"`
+	Fields      []mapFieldReport `json:"fields" yaml:"fields"`
+}
+
+func runMap(args []string) error {
+	flags := newFlagSet("map")
+	in := flags.String("in", "", "dump file to map")
+	format := flags.String("format", formatText, "output format: text, json or yaml")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+
+	reports := newRegionReports(dump)
+	switch *format {
+	case formatJSON, formatYAML:
+		return writeValue(os.Stdout, *format, reports)
+	case formatText:
+		writeMapText(os.Stdout, reports)
+		return nil
+	}
+	return usageErrorf("unknown format %q (want text, json or yaml)", *format)
+}
+
+func newRegionReports(dump *vcu.Dump) []regionReport {
+	var reports []regionReport
+	for _, info := range dump.Map() {
+		r := regionReport{
+			Name:        info.Name,
+			Start:       fmt.Sprintf("0x%08X", info.Address()),
+			End:         fmt.Sprintf("0x%08X", vcu.FlashBase+info.End-1),
+			Size:        info.Size(),
+			Erased:      info.Erased,
+			ErasedBytes: info.ErasedBytes,
+			SHA256:      info.SHA256,
+			Fields:      []mapFieldReport{},
+		}
+		data := dump.RegionBytes(info.Region)
+		for _, f := range info.Fields {
+			start := f.Offset - info.Start
+			r.Fields = append(r.Fields, mapFieldReport{
+				Name:   f.Name,
+				Offset: f.Offset,
+				Length: f.Length,
+				Value:  f.Format(data[start : start+f.Length]),
+			})
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func writeMapText(w io.Writer, reports []regionReport) {
+	for _, r := range reports {
+		state := fmt.Sprintf("%d/%d bytes erased", r.ErasedBytes, r.Size)
+		if r.Erased {
+			state = "erased"
+		}
+		_, _ = fmt.Fprintf(w, "\n📍 %-17s %s–%s %6d bytes, %s\n", r.Name, r.Start, r.End, r.Size, state)
+		_, _ = fmt.Fprintf(w, "   SHA-256: %s\n", r.SHA256)
+		for _, f := range r.Fields {
+			_, _ = fmt.Fprintf(w, "   %-12s 0x%05X: %s\n", f.Name, f.Offset, f.Value)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"change_sn/vcu"
+)
+
+var errIncompatible = errors.New("config sector and firmware use different layouts")
+
+// runMerge puts a config-only dump into a full template image so it can be
+// flashed.
+func runMerge(args []string) error {
+	flags := newFlagSet("merge")
+	in := flags.String("in", "", "config-only dump (0x1F000–0x1FFFF)")
+	template := flags.String("template", "", "firmware template version from the manifest, or a full dump file")
+	firmware := flags.String("firmware", "", "firmware version the config sector was read from, checked against the template")
+	force := flags.Bool("force", false, "merge even if the firmware or layout cannot be confirmed to match")
+	output := addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *template == "" {
+		return usageErrorf("missing --template")
+	}
+
+	config, err := loadDump(*in)
+	if err != nil {
+		return err
+	}
+	if !config.Partial() {
+		return usageErrorf("%s is a full dump, nothing to merge", *in)
+	}
+	base, err := loadTemplate(*template)
+	if err != nil {
+		return err
+	}
+	if err = checkMerge(*firmware, base, *force); err != nil {
+		return err
+	}
+	merged, err := config.MergeInto(base)
+	if err != nil {
+		return err
+	}
+	if len(merged.Serials()) == 0 {
+		if !*force {
+			return fmt.Errorf("%w: no serial at the offsets of layout %s (use --force to merge anyway)", vcu.ErrNoSerials, base.Layout().Name)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ No serial at the offsets of layout %s\n", base.Layout().Name)
+	}
+	fmt.Printf("✅ Config sector of %s merged into %s\n", *in, *template)
+
+	out := *output.out
+	if out == "" {
+		out = outputName(*in, "merged")
+	}
+	// Neither input is modified, so nothing is backed up. The journal
+	// records the changes against the template.
+	return writeDump(out, base, merged, saveOptions{
+		in:        *template,
+		overwrite: *output.overwrite,
+	})
+}
+
+// checkMerge refuses a template whose firmware is unknown or unsupported,
+// and a config sector read from a firmware with another layout, unless
+// force is set. A config-only dump has no application to detect its
+// firmware from, so its version is taken from the user.
+func checkMerge(configVersion string, base *vcu.Dump, force bool) error {
+	problem := func(err error) error {
+		if force {
+			_, _ = fmt.Fprintln(os.Stderr, "⚠️", err)
+			return nil
+		}
+		return fmt.Errorf("%w (use --force to merge anyway)", err)
+	}
+
+	fw := base.DetectFirmware()
+	if err := fw.Check(); errors.Is(err, vcu.ErrUnverifiedFirmware) {
+		_, _ = fmt.Fprintln(os.Stderr, "⚠️ template:", err)
+	} else if err != nil {
+		return problem(fmt.Errorf("template: %w", err))
+	}
+	if configVersion == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ Firmware of the config sector unknown, assuming %s (set --firmware to check)\n", fw.Version)
+		return nil
+	}
+
+	layout, ok := vcu.LayoutFor(configVersion, "")
+	switch {
+	case !ok:
+		return problem(fmt.Errorf("config sector: %w: %s", vcu.ErrUnsupportedFirmware, configVersion))
+	case layout.Name != base.Layout().Name:
+		return problem(fmt.Errorf("%w: %s (%s) and %s (%s)", errIncompatible, configVersion, layout.Name, fw.Version, base.Layout().Name))
+	case configVersion != fw.Version:
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ Config sector is from %s, template is %s; both use layout %s\n", configVersion, fw.Version, layout.Name)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package ninebot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// DefaultBaud is the bus speed of the scooter UART.
+const DefaultBaud = 115200
+
+// Registers of the ESC/VCU, as known from the MAX G30. Each index is a
+// 16-bit word, so a 14-byte serial spans seven registers.
+const (
+	RegSerial       = 0x10 // 14 ASCII characters
+	RegFirmware     = 0x1A // uint16, BCD-ish, e.g. 0x0156 for 1.5.6
+	RegErrorCode    = 0x1B // uint16
+	RegBattery      = 0x22 // uint16, percent
+	RegSpeed        = 0x26 // int16, 0.1 km/h
+	RegTotalMileage = 0x29 // uint32, metres
+	RegTripMileage  = 0x2F // uint16, 10 m
+	RegTemperature  = 0x3E // int16, 0.1 °C
+	// RegSpeedLimit is the normal mode limit on the G30; it has not been
+	// confirmed on the G3, so treat values read from it with care.
+	RegSpeedLimit = 0x72 // uint16, 0.1 km/h
+)
+
+var (
+	ErrTimeout  = errors.New("ninebot: no response")
+	ErrResponse = errors.New("ninebot: unexpected response")
+)
+
+// Reader reads frames from a byte stream, skipping noise between them.
+type Reader struct {
+	s *bufio.Scanner
+}
+
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 1024), 4*(overhead+MaxPayload))
+	s.Split(SplitFrames)
+	return &Reader{s: s}
+}
+
+// ReadFrame returns the next valid frame. It returns io.EOF at the end of
+// the stream.
+func (r *Reader) ReadFrame() (Frame, error) {
+	if !r.s.Scan() {
+		if err := r.s.Err(); err != nil {
+			return Frame{}, err
+		}
+		return Frame{}, io.EOF
+	}
+	return Decode(r.s.Bytes())
+}
+
+// Client reads and writes registers of a device on the bus.
+type Client struct {
+	rw io.ReadWriter
+	// Src is the address requests are sent from.
+	Src byte
+	// Timeout bounds the wait for a response.
+	Timeout time.Duration
+
+	frames chan Frame
+	err    error // why frames was closed
+}
+
+// NewClient talks over rw. Frames are read in the background until rw
+// returns an error, so the client should be closed when done.
+func NewClient(rw io.ReadWriter) *Client {
+	c := &Client{
+		rw:      rw,
+		Src:     AddrApp,
+		Timeout: 500 * time.Millisecond,
+		frames:  make(chan Frame, 16),
+	}
+	go c.readLoop(NewReader(idleReader{rw}))
+	return c
+}
+
+// Open opens the serial port of the bus at 8N1.
+func Open(name string, baud int) (*Client, error) {
+	port, err := serial.Open(name, &serial.Mode{BaudRate: baud, DataBits: 8, StopBits: serial.OneStopBit})
+	if err != nil {
+		return nil, err
+	}
+	if err = port.SetReadTimeout(100 * time.Millisecond); err != nil {
+		_ = port.Close()
+		return nil, err
+	}
+	return NewClient(port), nil
+}
+
+// Close closes the underlying connection if it can be closed.
+func (c *Client) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// idleReader retries reads that time out without data, as a serial port
+// with a read timeout does, so the scanner does not treat them as EOF.
+type idleReader struct {
+	r io.Reader
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.r.Read(p)
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *Client) readLoop(r *Reader) {
+	for {
+		f, err := r.ReadFrame()
+		if err != nil {
+			c.err = err
+			close(c.frames)
+			return
+		}
+		select {
+		case c.frames <- f:
+		default:
+			// Nobody is waiting; drop bus traffic rather than block.
+		}
+	}
+}
+
+// Send writes a frame without waiting for a response.
+func (c *Client) Send(f Frame) error {
+	b, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = c.rw.Write(b)
+	return err
+}
+
+// Frames returns the frames received on the bus, for listening to traffic
+// when no request is pending; Request reads from the same channel. The
+// channel is closed when the connection fails.
+func (c *Client) Frames() <-chan Frame {
+	return c.frames
+}
+
+// Request sends f and waits for the response from its destination with
+// the given command and the same index.
+func (c *Client) Request(f Frame, response byte) (Frame, error) {
+	// Drop stale frames so an old response is not taken for this one.
+	for len(c.frames) > 0 {
+		<-c.frames
+	}
+	if err := c.Send(f); err != nil {
+		return Frame{}, err
+	}
+
+	timeout := time.After(c.Timeout)
+	for {
+		select {
+		case r, ok := <-c.frames:
+			if !ok {
+				return Frame{}, c.err
+			}
+			if r.Src == f.Dst && r.Cmd == response && r.Index == f.Index {
+				return r, nil
+			}
+		case <-timeout:
+			return Frame{}, fmt.Errorf("%w from %02X to cmd %02X idx %02X", ErrTimeout, f.Dst, f.Cmd, f.Index)
+		}
+	}
+}
+
+// ReadRegister reads n bytes starting at register index of device dst.
+func (c *Client) ReadRegister(dst, index byte, n int) ([]byte, error) {
+	if n < 1 || n > MaxPayload {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayload, n)
+	}
+	r, err := c.Request(Frame{Src: c.Src, Dst: dst, Cmd: CmdRead, Index: index, Payload: []byte{byte(n)}}, CmdReadResponse)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Payload) != n {
+		return nil, fmt.Errorf("%w: read %02X returned %d bytes, want %d", ErrResponse, index, len(r.Payload), n)
+	}
+	return r.Payload, nil
+}
+
+// WriteRegister writes data starting at register index of device dst and
+// waits for the acknowledgement.
+func (c *Client) WriteRegister(dst, index byte, data []byte) error {
+	r, err := c.Request(Frame{Src: c.Src, Dst: dst, Cmd: CmdWrite, Index: index, Payload: data}, CmdWriteResponse)
+	if err != nil {
+		return err
+	}
+	if len(r.Payload) > 0 && r.Payload[0] != 0x01 {
+		return fmt.Errorf("%w: write %02X rejected with %02X", ErrResponse, index, r.Payload[0])
+	}
+	return nil
+}
+
+// ReadUint16 reads a little-endian 16-bit register.
+func (c *Client) ReadUint16(dst, index byte) (uint16, error) {
+	b, err := c.ReadRegister(dst, index, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+// ReadUint32 reads a little-endian 32-bit value spanning two registers.
+func (c *Client) ReadUint32(dst, index byte) (uint32, error) {
+	b, err := c.ReadRegister(dst, index, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// WriteUint16 writes a little-endian 16-bit register.
+func (c *Client) WriteUint16(dst, index byte, v uint16) error {
+	return c.WriteRegister(dst, index, binary.LittleEndian.AppendUint16(nil, v))
+}
+
+// FirmwareVersion formats a RegFirmware value as "1.5.6".
+func FirmwareVersion(v uint16) string {
+	return fmt.Sprintf("%d.%d.%d", v>>8&0x0F, v>>4&0x0F, v&0x0F)
+}
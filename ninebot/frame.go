@@ -0,0 +1,179 @@
+// Package ninebot encodes and decodes the 0x5A 0xA5 framed messages used
+// on the Ninebot scooter UART bus, and reads and writes registers of a
+// live controller with them.
+//
+// A frame is
+//
+//	5A A5 LEN SRC DST CMD IDX PAYLOAD... CK0 CK1
+//
+// where LEN is the payload length and the checksum is the 16-bit sum of
+// LEN through the last payload byte, inverted and sent little-endian.
+package ninebot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Header bytes that start every frame.
+const (
+	Header0 = 0x5A
+	Header1 = 0xA5
+)
+
+// MaxPayload is the largest payload the length byte can describe.
+const MaxPayload = 0xFF
+
+// overhead is the size of a frame without payload: two header bytes, the
+// length, source, destination, command and index, and two checksum bytes.
+const overhead = 9
+
+// Bus addresses.
+const (
+	AddrESC  = 0x20 // the motor controller; on the G3 the VCU answers here
+	AddrBLE  = 0x21
+	AddrBMS  = 0x22
+	AddrBMS2 = 0x23
+	AddrApp  = 0x3D // tools and apps talking to the bus
+)
+
+// Commands.
+const (
+	CmdRead          = 0x01 // payload: number of bytes to read
+	CmdWrite         = 0x02 // payload: bytes to write, acknowledged
+	CmdWriteNoAck    = 0x03
+	CmdReadResponse  = 0x04
+	CmdWriteResponse = 0x05
+)
+
+var (
+	ErrHeader   = errors.New("ninebot: missing 0x5A 0xA5 header")
+	ErrShort    = errors.New("ninebot: frame too short")
+	ErrLength   = errors.New("ninebot: length does not match frame size")
+	ErrChecksum = errors.New("ninebot: checksum mismatch")
+	ErrPayload  = errors.New("ninebot: payload too long")
+)
+
+// Frame is one message on the bus. Index is the register the command
+// applies to; registers are 16-bit words.
+type Frame struct {
+	Src     byte
+	Dst     byte
+	Cmd     byte
+	Index   byte
+	Payload []byte
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%02X->%02X cmd %02X idx %02X % X", f.Src, f.Dst, f.Cmd, f.Index, f.Payload)
+}
+
+// Checksum returns the inverted 16-bit sum of b.
+func Checksum(b []byte) uint16 {
+	var sum uint16
+	for _, v := range b {
+		sum += uint16(v)
+	}
+	return ^sum
+}
+
+// MarshalBinary encodes the frame.
+func (f Frame) MarshalBinary() ([]byte, error) {
+	if len(f.Payload) > MaxPayload {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayload, len(f.Payload))
+	}
+	b := make([]byte, 0, overhead+len(f.Payload))
+	b = append(b, Header0, Header1, byte(len(f.Payload)), f.Src, f.Dst, f.Cmd, f.Index)
+	b = append(b, f.Payload...)
+	return binary.LittleEndian.AppendUint16(b, Checksum(b[2:])), nil
+}
+
+// Decode parses one complete frame. The payload is copied.
+func Decode(b []byte) (Frame, error) {
+	if len(b) < overhead {
+		return Frame{}, ErrShort
+	}
+	if b[0] != Header0 || b[1] != Header1 {
+		return Frame{}, ErrHeader
+	}
+	if len(b) != overhead+int(b[2]) {
+		return Frame{}, fmt.Errorf("%w: length %d, frame %d bytes", ErrLength, b[2], len(b))
+	}
+	end := len(b) - 2
+	if got, want := binary.LittleEndian.Uint16(b[end:]), Checksum(b[2:end]); got != want {
+		return Frame{}, fmt.Errorf("%w: got %04X, want %04X", ErrChecksum, got, want)
+	}
+	return Frame{
+		Src:     b[3],
+		Dst:     b[4],
+		Cmd:     b[5],
+		Index:   b[6],
+		Payload: append([]byte(nil), b[7:end]...),
+	}, nil
+}
+
+// SplitFrames is a bufio.SplitFunc that yields complete frames from a byte
+// stream. Bytes before a header and frames with a bad checksum are
+// skipped, so the scanner resynchronises after line noise. While a frame
+// is incomplete, a complete valid frame further on means the first header
+// was noise, so a false header with a large length does not hold up the
+// frames behind it.
+func SplitFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for {
+		start := indexHeader(data[advance:])
+		if start < 0 {
+			// Keep a trailing 0x5A, it may be the start of a header.
+			skip := len(data)
+			if skip > advance && data[skip-1] == Header0 {
+				skip--
+			}
+			return skip, nil, nil
+		}
+		advance += start
+		rest := data[advance:]
+		if len(rest) < 3 || len(rest) < overhead+int(rest[2]) {
+			if atEOF {
+				return len(data), nil, nil
+			}
+			if next := indexFrame(rest[1:]); next >= 0 {
+				advance += 1 + next
+				continue
+			}
+			return advance, nil, nil
+		}
+		n := overhead + int(rest[2])
+		if _, err := Decode(rest[:n]); err != nil {
+			// Not a real frame start; look for the next header.
+			advance++
+			continue
+		}
+		return advance + n, rest[:n], nil
+	}
+}
+
+// indexFrame returns the start of the first complete valid frame in b, or
+// -1.
+func indexFrame(b []byte) int {
+	for i := 0; ; i++ {
+		start := indexHeader(b[i:])
+		if start < 0 {
+			return -1
+		}
+		i += start
+		if n := len(b) - i; n >= 3 && n >= overhead+int(b[i+2]) {
+			if _, err := Decode(b[i : i+overhead+int(b[i+2])]); err == nil {
+				return i
+			}
+		}
+	}
+}
+
+func indexHeader(b []byte) int {
+	for i := 0; i+1 < len(b); i++ {
+		if b[i] == Header0 && b[i+1] == Header1 {
+			return i
+		}
+	}
+	return -1
+}
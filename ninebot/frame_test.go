@@ -0,0 +1,133 @@
+package ninebot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func mustMarshal(t *testing.T, f Frame) []byte {
+	t.Helper()
+	b, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMarshalBinary(t *testing.T) {
+	// Read of the 14-byte serial at register 0x10 by an app.
+	f := Frame{Src: AddrApp, Dst: AddrESC, Cmd: CmdRead, Index: RegSerial, Payload: []byte{0x0E}}
+	want := []byte{0x5A, 0xA5, 0x01, 0x3D, 0x20, 0x01, 0x10, 0x0E, 0x82, 0xFF}
+	if got := mustMarshal(t, f); !bytes.Equal(got, want) {
+		t.Errorf("MarshalBinary = % X, want % X", got, want)
+	}
+	if _, err := (Frame{Payload: make([]byte, MaxPayload+1)}).MarshalBinary(); !errors.Is(err, ErrPayload) {
+		t.Errorf("oversized payload: %v, want ErrPayload", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	valid := mustMarshal(t, Frame{Src: AddrESC, Dst: AddrApp, Cmd: CmdReadResponse, Index: 0x1A, Payload: []byte{0x56, 0x01}})
+	badSum := bytes.Clone(valid)
+	badSum[len(badSum)-1] ^= 0xFF
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		want error
+	}{
+		{"valid", valid, nil},
+		{"short", valid[:8], ErrShort},
+		{"header", append([]byte{0x5A, 0x5A}, valid[2:]...), ErrHeader},
+		{"length", append(bytes.Clone(valid), 0x00), ErrLength},
+		{"checksum", badSum, ErrChecksum},
+	} {
+		f, err := Decode(tt.in)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if err == nil && (f.Index != 0x1A || !bytes.Equal(f.Payload, []byte{0x56, 0x01})) {
+			t.Errorf("%s: decoded %v", tt.name, f)
+		}
+	}
+}
+
+// chunkReader returns its data at most n bytes per Read, like a serial
+// port delivering a frame over several reads.
+type chunkReader struct {
+	data []byte
+	n    int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), r.n)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func scanFrames(t *testing.T, stream []byte, chunk int) [][]byte {
+	t.Helper()
+	s := bufio.NewScanner(&chunkReader{data: stream, n: chunk})
+	s.Split(SplitFrames)
+	var frames [][]byte
+	for s.Scan() {
+		frames = append(frames, bytes.Clone(s.Bytes()))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestSplitFrames(t *testing.T) {
+	a := mustMarshal(t, Frame{Src: AddrApp, Dst: AddrESC, Cmd: CmdRead, Index: 0x10, Payload: []byte{0x0E}})
+	// A payload holding a header must not split the frame.
+	b := mustMarshal(t, Frame{Src: AddrESC, Dst: AddrApp, Cmd: CmdReadResponse, Index: 0x10, Payload: []byte{0x5A, 0xA5, 0x02, 0x5A}})
+	noise := []byte{0x00, 0x5A, 0x13, 0xFF}
+	falseHeader := []byte{0x5A, 0xA5, 0xF0, 0x01} // claims 240 bytes
+
+	cat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	for _, tt := range []struct {
+		name   string
+		stream []byte
+		want   [][]byte
+	}{
+		{"frames", cat(a, b), [][]byte{a, b}},
+		{"noise before and between", cat(noise, a, noise, b), [][]byte{a, b}},
+		{"bad checksum skipped", cat(a[:len(a)-1], []byte{0x00}, b), [][]byte{b}},
+		{"false header with large length", cat(falseHeader, a, b), [][]byte{a, b}},
+		{"trailing partial frame", cat(a, b[:5]), [][]byte{a}},
+	} {
+		for _, chunk := range []int{1, 3, 4096} {
+			got := scanFrames(t, tt.stream, chunk)
+			if len(got) != len(tt.want) {
+				t.Errorf("%s, %d-byte reads: %d frames % X, want %d", tt.name, chunk, len(got), got, len(tt.want))
+				continue
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("%s, %d-byte reads: frame %d is % X, want % X", tt.name, chunk, i, got[i], tt.want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestSplitFramesResyncsWithoutWaiting(t *testing.T) {
+	// The false header claims more bytes than have arrived. The valid
+	// frame behind it must come out now, not after 249 more bytes.
+	a := mustMarshal(t, Frame{Src: AddrApp, Dst: AddrESC, Cmd: CmdRead, Index: 0x10, Payload: []byte{0x0E}})
+	data := append([]byte{0x5A, 0xA5, 0xF0, 0x01, 0x02}, a...)
+	advance, token, err := SplitFrames(data, false)
+	if err != nil || !bytes.Equal(token, a) || advance != len(data) {
+		t.Errorf("SplitFrames = %d, % X, %v; want %d, % X", advance, token, err, len(data), a)
+	}
+}
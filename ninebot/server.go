@@ -0,0 +1,61 @@
+package ninebot
+
+import (
+	"io"
+)
+
+// Registers is the register file of a device answering on the bus.
+type Registers interface {
+	// ReadRegisters returns n bytes starting at register index.
+	ReadRegisters(index byte, n int) ([]byte, error)
+	// WriteRegisters stores data starting at register index. An error
+	// rejects the write.
+	WriteRegisters(index byte, data []byte) error
+}
+
+// Serve answers the read and write requests addressed to addr on rw from
+// regs, until rw fails. Reads that regs cannot serve are left unanswered,
+// as a real controller does; rejected writes are acknowledged with 0x00.
+func Serve(rw io.ReadWriter, addr byte, regs Registers) error {
+	r := NewReader(idleReader{rw})
+	for {
+		f, err := r.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if f.Dst != addr {
+			continue
+		}
+
+		resp := Frame{Src: addr, Dst: f.Src, Index: f.Index}
+		switch f.Cmd {
+		case CmdRead:
+			if len(f.Payload) != 1 {
+				continue
+			}
+			data, err := regs.ReadRegisters(f.Index, int(f.Payload[0]))
+			if err != nil {
+				continue
+			}
+			resp.Cmd, resp.Payload = CmdReadResponse, data
+		case CmdWrite:
+			resp.Cmd, resp.Payload = CmdWriteResponse, []byte{0x01}
+			if err := regs.WriteRegisters(f.Index, f.Payload); err != nil {
+				resp.Payload[0] = 0x00
+			}
+		case CmdWriteNoAck:
+			_ = regs.WriteRegisters(f.Index, f.Payload)
+			continue
+		default:
+			continue
+		}
+
+		b, err := resp.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err = rw.Write(b); err != nil {
+			return err
+		}
+	}
+}
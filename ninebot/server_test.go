@@ -0,0 +1,126 @@
+package ninebot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// fakeRegisters is a register file of 0x80 registers. Registers from
+// 0x40 on are read-only.
+type fakeRegisters struct {
+	mu   sync.Mutex
+	regs []byte
+}
+
+func newFakeRegisters() *fakeRegisters {
+	return &fakeRegisters{regs: make([]byte, 0x80*2)}
+}
+
+func (r *fakeRegisters) ReadRegisters(index byte, n int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := int(index) * 2
+	if start+n > len(r.regs) {
+		return nil, fmt.Errorf("read %02X+%d outside the register file", index, n)
+	}
+	return bytes.Clone(r.regs[start : start+n]), nil
+}
+
+func (r *fakeRegisters) WriteRegisters(index byte, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := int(index) * 2
+	if index >= 0x40 || start+len(data) > 0x40*2 {
+		return errors.New("read-only")
+	}
+	copy(r.regs[start:], data)
+	return nil
+}
+
+// servePty serves regs as the ESC on the master side of a raw pty, as the
+// emulator does, and returns a client on its terminal side.
+func servePty(t *testing.T, regs Registers) *Client {
+	t.Helper()
+	master, tty, err := pty.Open()
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = tty.Close()
+	})
+	if _, err = term.MakeRaw(int(tty.Fd())); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = Serve(master, AddrESC, regs)
+	}()
+	c := NewClient(tty)
+	c.Timeout = 300 * time.Millisecond
+	return c
+}
+
+// controlBytes are bytes a terminal in cooked mode would swallow or
+// translate: ^C, line feed, carriage return, XON, XOFF, ^Z and DEL.
+var controlBytes = []byte{0x03, 0x0A, 0x0D, 0x11, 0x13, 0x1A, 0x7F, 0x0D, 0x0A, 0x5A, 0xA5, 0x04, 0x1C, 0x15}
+
+func TestServeRead(t *testing.T) {
+	regs := newFakeRegisters()
+	copy(regs.regs[RegSerial*2:], controlBytes)
+	c := servePty(t, regs)
+
+	got, err := c.ReadRegister(AddrESC, RegSerial, len(controlBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, controlBytes) {
+		t.Errorf("read % X, want % X", got, controlBytes)
+	}
+}
+
+func TestServeWrite(t *testing.T) {
+	regs := newFakeRegisters()
+	c := servePty(t, regs)
+
+	if err := c.WriteRegister(AddrESC, RegSerial, controlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := regs.ReadRegisters(RegSerial, len(controlBytes)); !bytes.Equal(got, controlBytes) {
+		t.Errorf("stored % X, want % X", got, controlBytes)
+	}
+	// Rejected writes are acknowledged with 0x00 and change nothing.
+	if err := c.WriteRegister(AddrESC, 0x40, []byte{0x01, 0x02}); !errors.Is(err, ErrResponse) {
+		t.Errorf("write to a read-only register: %v, want ErrResponse", err)
+	}
+	if got, _ := regs.ReadRegisters(0x40, 2); !bytes.Equal(got, []byte{0, 0}) {
+		t.Errorf("read-only register changed to % X", got)
+	}
+}
+
+func TestServeLeavesRequestsUnanswered(t *testing.T) {
+	c := servePty(t, newFakeRegisters())
+
+	for _, tt := range []struct {
+		name       string
+		dst, index byte
+		n          int
+	}{
+		{"read outside the register file", AddrESC, 0x7F, 4},
+		{"other device", AddrBMS, RegSerial, 14},
+	} {
+		if _, err := c.ReadRegister(tt.dst, tt.index, tt.n); !errors.Is(err, ErrTimeout) {
+			t.Errorf("%s: %v, want ErrTimeout", tt.name, err)
+		}
+	}
+	// The server is still answering.
+	if _, err := c.ReadRegister(AddrESC, RegSerial, 14); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package openocd talks to a running OpenOCD over its TCL-RPC server to
+// read and program the VCU flash through an SWD probe.
+//
+// Commands are sent as text terminated by 0x1A and OpenOCD answers with
+// the command result terminated the same way. Every command is wrapped in
+// a Tcl catch so failures can be told apart from normal output.
+package openocd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"change_sn/vcu"
+)
+
+// DefaultAddr is where OpenOCD listens for TCL-RPC by default.
+const DefaultAddr = "localhost:6666"
+
+const terminator = 0x1A
+
+// readChunk is the number of bytes fetched per read_memory command.
+const readChunk = 1024
+
+var ErrProtocol = errors.New("openocd: unexpected response")
+
+// CommandError is a Tcl error returned by OpenOCD for a command.
+type CommandError struct {
+	Command string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("openocd: %s: %s", e.Command, e.Message)
+}
+
+// Client is a TCL-RPC connection to OpenOCD. It implements vcu.Target.
+type Client struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	Timeout time.Duration
+}
+
+// Dial connects to the OpenOCD TCL-RPC server at addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, r: bufio.NewReader(conn), Timeout: 30 * time.Second}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Command runs a Tcl command and returns its result.
+func (c *Client) Command(cmd string) (string, error) {
+	if c.Timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+			return "", err
+		}
+	}
+	wrapped := fmt.Sprintf("format \"%%d %%s\" [catch {%s} _change_sn_result] $_change_sn_result", cmd)
+	if _, err := c.conn.Write(append([]byte(wrapped), terminator)); err != nil {
+		return "", err
+	}
+	resp, err := c.r.ReadString(terminator)
+	if err != nil {
+		return "", err
+	}
+
+	code, msg, _ := strings.Cut(strings.TrimSuffix(resp, string(rune(terminator))), " ")
+	switch code {
+	case "0":
+		return strings.TrimSpace(msg), nil
+	case "1":
+		return "", &CommandError{Command: cmd, Message: strings.TrimSpace(msg)}
+	}
+	return "", fmt.Errorf("%w to %q: %q", ErrProtocol, cmd, resp)
+}
+
+// Halt stops the target so its flash can be accessed.
+func (c *Client) Halt() error {
+	_, err := c.Command("halt")
+	return err
+}
+
+// Reset resets the target and lets it run.
+func (c *Client) Reset() error {
+	_, err := c.Command("reset run")
+	return err
+}
+
+// ReadFlash reads n bytes of flash starting at offset.
+func (c *Client) ReadFlash(offset, n int) ([]byte, error) {
+	data := make([]byte, 0, n)
+	for len(data) < n {
+		count := min(readChunk, n-len(data))
+		addr := vcu.FlashBase + offset + len(data)
+		resp, err := c.Command(fmt.Sprintf("read_memory 0x%08X 8 %d", addr, count))
+		if err != nil {
+			return nil, err
+		}
+		values := strings.Fields(resp)
+		if len(values) != count {
+			return nil, fmt.Errorf("%w: read_memory 0x%08X returned %d bytes, want %d", ErrProtocol, addr, len(values), count)
+		}
+		for _, v := range values {
+			b, err := strconv.ParseUint(v, 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%w: read_memory 0x%08X: %v", ErrProtocol, addr, err)
+			}
+			data = append(data, byte(b))
+		}
+	}
+	return data, nil
+}
+
+// ProgramPage erases the flash page at offset and programs data into it
+// with a single flash write_image. The page is handed over in a temporary
+// file, so OpenOCD has to run on the same machine.
+func (c *Client) ProgramPage(offset int, data []byte) error {
+	addr := vcu.FlashBase + offset
+	if _, err := c.Command(fmt.Sprintf("flash erase_address 0x%08X %d", addr, len(data))); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "change_sn-page-*.bin")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// Forward slashes work for OpenOCD on Windows too and need no Tcl
+	// escaping.
+	_, err = c.Command(fmt.Sprintf("flash write_image {%s} 0x%08X bin", filepath.ToSlash(f.Name()), addr))
+	return err
+}
@@ -0,0 +1,231 @@
+package openocd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"change_sn/vcu"
+)
+
+// fakeOpenOCD answers the TCL-RPC commands the client sends, backed by a
+// flash image with NOR semantics: erase sets bytes to 0xFF and programming
+// can only clear bits.
+type fakeOpenOCD struct {
+	mu       sync.Mutex
+	flash    []byte
+	halted   bool
+	commands []string
+}
+
+func newFakeOpenOCD(t *testing.T, flash []byte) (*fakeOpenOCD, string) {
+	t.Helper()
+	f := &fakeOpenOCD{flash: bytes.Clone(flash)}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeOpenOCD) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		req, err := r.ReadString(terminator)
+		if err != nil {
+			return
+		}
+		// Unwrap the catch added by Client.Command.
+		cmd := strings.TrimSuffix(req, string(rune(terminator)))
+		start, end := strings.Index(cmd, "[catch {"), strings.LastIndex(cmd, "} _change_sn_result]")
+		if start < 0 || end < 0 {
+			_, _ = conn.Write([]byte("bad request\x1a"))
+			continue
+		}
+		result, err := f.run(cmd[start+len("[catch {") : end])
+		code := 0
+		if err != nil {
+			code, result = 1, err.Error()
+		}
+		_, _ = fmt.Fprintf(conn, "%d %s\x1a", code, result)
+	}
+}
+
+func (f *fakeOpenOCD) run(cmd string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, cmd)
+
+	args := strings.Fields(cmd)
+	num := func(i int) int {
+		n, _ := strconv.ParseInt(args[i], 0, 64)
+		return int(n)
+	}
+	switch {
+	case cmd == "halt":
+		f.halted = true
+		return "", nil
+	case cmd == "reset run":
+		f.halted = false
+		return "", nil
+	case len(args) == 4 && args[0] == "read_memory" && args[2] == "8":
+		offset, n := num(1)-vcu.FlashBase, num(3)
+		if offset < 0 || offset+n > len(f.flash) {
+			return "", errors.New("read_memory: address out of range")
+		}
+		values := make([]string, n)
+		for i, b := range f.flash[offset : offset+n] {
+			values[i] = fmt.Sprintf("0x%02x", b)
+		}
+		return strings.Join(values, " "), nil
+	case len(args) == 4 && args[0] == "flash" && args[1] == "erase_address":
+		if !f.halted {
+			return "", errors.New("target not halted")
+		}
+		offset := num(2) - vcu.FlashBase
+		for i := offset; i < offset+num(3); i++ {
+			f.flash[i] = 0xFF
+		}
+		return "", nil
+	case len(args) == 5 && args[0] == "flash" && args[1] == "write_image" && args[4] == "bin":
+		if !f.halted {
+			return "", errors.New("target not halted")
+		}
+		data, err := os.ReadFile(strings.Trim(args[2], "{}"))
+		if err != nil {
+			return "", err
+		}
+		offset := num(3) - vcu.FlashBase
+		for i, b := range data {
+			f.flash[offset+i] &= b
+		}
+		return fmt.Sprintf("wrote %d bytes from file", len(data)), nil
+	}
+	return "", fmt.Errorf("invalid command name %q", args[0])
+}
+
+// count returns how many commands started with prefix.
+func (f *fakeOpenOCD) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, cmd := range f.commands {
+		if strings.HasPrefix(cmd, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func testImage() []byte {
+	image := make([]byte, vcu.DumpSize)
+	for i := range image {
+		image[i] = byte(i * 7)
+	}
+	return image
+}
+
+func TestReadFlash(t *testing.T) {
+	image := testImage()
+	_, addr := newFakeOpenOCD(t, image)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	got, err := c.ReadFlash(0x1F000, 2*readChunk+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, image[0x1F000:0x1F000+2*readChunk+10]) {
+		t.Error("ReadFlash returned the wrong bytes")
+	}
+}
+
+func TestWriteTargetProgramsChangedPagesOnly(t *testing.T) {
+	image := testImage()
+	fake, addr := newFakeOpenOCD(t, image)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err = c.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := vcu.ReadTarget(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := current.Clone()
+	patched.Bytes()[0x10] = 0x00              // first config page
+	patched.Bytes()[vcu.ConfigSize-1] ^= 0xFF // last page, needs an erase
+	pages, err := vcu.WriteTarget(c, current, patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("wrote pages %X, want 2", pages)
+	}
+	if n := fake.count("flash write_image"); n != len(pages) {
+		t.Errorf("%d write_image commands for %d pages, want one per page", n, len(pages))
+	}
+	if n := fake.count("flash fillw"); n != 0 {
+		t.Errorf("%d fillw commands, want none", n)
+	}
+	if !bytes.Equal(fake.flash[vcu.ConfigAOffset:], patched.Bytes()) {
+		t.Error("flash does not match the patched dump")
+	}
+	if !bytes.Equal(fake.flash[:vcu.ConfigAOffset], image[:vcu.ConfigAOffset]) {
+		t.Error("pages outside the config sector were changed")
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	_, addr := newFakeOpenOCD(t, testImage())
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	// The fake refuses to erase a running target.
+	err = c.ProgramPage(0x1F000, make([]byte, vcu.PageSize))
+	var ce *CommandError
+	if !errors.As(err, &ce) || !strings.Contains(ce.Message, "not halted") {
+		t.Fatalf("ProgramPage on a running target: %v, want a CommandError", err)
+	}
+	if _, err = c.Command("no_such_command"); !errors.As(err, &ce) {
+		t.Errorf("unknown command: %v, want a CommandError", err)
+	}
+}
@@ -2,14 +2,13 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/base64"
-	"encoding/binary"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
+	"change_sn/vcu"
 	"github.com/chzyer/readline"
 )
 
@@ -35,73 +34,87 @@ func editOwn(verify *bool, reader *bufio.Reader) {
 		os.Exit(1)
 	}
 
-	data, err := os.ReadFile(fileName)
+	// Loaded rather than parsed so the patched file keeps the record style
+	// of a .hex or .s19 input.
+	dump, err := vcu.Load(fileName)
+	if errors.Is(err, vcu.ErrSize) {
+		_, _ = fmt.Fprintln(os.Stderr, "❌ File corrupted")
+		os.Exit(1)
+	}
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, "❌ Error reading file:", err)
 		os.Exit(1)
 	}
 
-	verifyFile(data, err, fileName)
+	verifyFile(dump)
+	original := dump.Clone()
 
-	changeSn(data, verify, reader)
+	confirmFirmware(dump, verify, reader)
 
-	changeMileage(data, reader)
+	confirmConsistency(dump, verify, reader)
 
-	changeSpeed(data, reader)
+	changeSn(dump, verify, reader)
 
-	transferKey(data, reader)
+	changeMileage(dump, reader)
 
-	outFile := fileName + ".patched.bin"
-	err = os.WriteFile(outFile, data, 0644)
+	changeSpeed(dump, reader)
+
+	keySource := transferKey(dump, reader)
+
+	outFile := outputName(fileName, "patched")
+	journal, err := saveDump(outFile, original, dump, saveOptions{
+		in:        fileName,
+		backup:    true,
+		keySource: keySource,
+	})
 	if err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, "❌ Error writing output file:", err)
-		_, _ = reader.ReadString('\n')
-		os.Exit(1)
+		fail(reader, "❌ Error writing output file:", err)
 	}
 	fmt.Println("✅ All changes written to:", outFile)
+	fmt.Println("📝 Change journal written to:", journal)
 	_, _ = reader.ReadString('\n')
 }
 
-func verifyFile(data []byte, err error, fileName string) {
+func verifyFile(dump *vcu.Dump) {
 	//verify length
-	if len(data) != 0x20000 {
-		_, _ = fmt.Fprintln(os.Stderr, "❌ File corrupted")
-		os.Exit(1)
-	}
-	fmt.Printf("✅ Len correct: %d\n", len(data))
+	fmt.Printf("✅ Len correct: %d\n", len(dump.Bytes()))
 
 	//verif header
-	expected, err := hex.DecodeString(header[:len(header)-(len(header)%2)])
-	if err != nil {
-		panic("\n❌ invalid header signature. File corrupted: " + err.Error())
+	if dump.Partial() {
+		fmt.Println("\n⚠️ Config-only dump (0x1F000–0x1FFFF). No header to check")
+	} else if dump.HeaderValid() {
+		fmt.Println("\n✅ VALID header signature. Dump seems to be correct")
+	} else {
+		fmt.Println("\n❌ invalid header signature. File corrupted")
 		os.Exit(1)
 	}
+}
 
-	valid, err := isDumpHeaderValid(fileName, expected)
-	if err != nil {
-		fmt.Println("\n❌ error:", err)
-		os.Exit(1)
+// confirmFirmware reports the detected firmware and asks before editing one
+// whose field offsets are known not to be verified.
+func confirmFirmware(dump *vcu.Dump, verify *bool, reader *bufio.Reader) {
+	fw := dump.DetectFirmware()
+	err := fw.Check()
+	if err == nil {
+		fmt.Println("✅ Detected firmware:", fw)
+		return
 	}
-	if valid {
-		fmt.Println("\n✅ VALID header signature. Dump seems to be correct")
-	} else {
-		fmt.Println("\n❌ invalid header signature. File corrupted")
+
+	fmt.Printf("\n⚠️ %v\n", err)
+	if *verify || errors.Is(err, vcu.ErrUnknownFirmware) || errors.Is(err, vcu.ErrUnverifiedFirmware) {
+		return
+	}
+	fmt.Print("Offsets may be wrong. Continue anyway? (Y/N): ")
+	answer, _ := reader.ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
 		os.Exit(1)
 	}
 }
 
-func changeSn(data []byte, verify *bool, reader *bufio.Reader) {
+func changeSn(dump *vcu.Dump, verify *bool, reader *bufio.Reader) {
 	fmt.Println("\nFound serial numbers:")
-	for i := 0; i <= len(data)-serialLength; i++ {
-		if bytes.Equal(data[i:i+3], []byte(prefix)) {
-			sn := data[i : i+serialLength]
-			if bytes.Equal(sn, []byte(skipSerial)) {
-				i += serialLength - 1
-				continue
-			}
-			fmt.Printf("-> %s\n", string(sn))
-			i += serialLength - 1
-		}
+	for _, sn := range dump.Serials() {
+		fmt.Printf("-> %s\n", sn.Value)
 	}
 
 	if *verify {
@@ -117,25 +130,14 @@ func changeSn(data []byte, verify *bool, reader *bufio.Reader) {
 	if answer == "y" {
 		fmt.Print("Enter new serial number (must be 14 characters): ")
 		newSerial, _ := reader.ReadString('\n')
-		newSerial = strings.ToUpper(strings.TrimSpace(newSerial))
-		if len(newSerial) != serialLength {
-			_, _ = fmt.Fprintln(os.Stderr, "❌ Invalid serial number format")
-			_, _ = reader.ReadString('\n')
-			os.Exit(1)
-		}
 
-		SetSn(data, newSerial, reader)
+		SetSn(dump, newSerial, reader)
 	}
 }
 
-func changeSpeed(data []byte, reader *bufio.Reader) {
+func changeSpeed(dump *vcu.Dump, reader *bufio.Reader) {
 	fmt.Println("🚀 Current speed values:")
-	for _, offset := range speedOffsets {
-		val, err := readByteAt(data, offset)
-		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "❌ Failed to read speed value\n")
-			continue
-		}
+	for _, val := range dump.Speeds() {
 		fmt.Printf("-> %d (0x%02X)\n", val, val)
 	}
 
@@ -148,13 +150,12 @@ func changeSpeed(data []byte, reader *bufio.Reader) {
 		speedStr, _ := reader.ReadString('\n')
 		speedStr = strings.TrimSpace(speedStr)
 
-		SetSpeed(data, speedStr, reader)
+		SetSpeed(dump, speedStr, reader)
 	}
 }
 
-func changeMileage(data []byte, reader *bufio.Reader) string {
-	old1, _ := readUint16At(data, speedOffset1)
-	old2, _ := readUint16At(data, speedOffset2)
+func changeMileage(dump *vcu.Dump, reader *bufio.Reader) string {
+	old1, old2 := dump.Mileage()
 	fmt.Printf("🚗 Current mileage A: %d (%.1f km)\n", old1, float64(old1)/10.0)
 	fmt.Printf("🚗 Current mileage B: %d (%.1f km)\n", old2, float64(old2)/10.0)
 
@@ -167,14 +168,15 @@ func changeMileage(data []byte, reader *bufio.Reader) string {
 		mileageStr, _ := reader.ReadString('\n')
 		mileageStr = strings.TrimSpace(mileageStr)
 
-		SetMileage(data, mileageStr, reader)
+		SetMileage(dump, mileageStr, reader)
 	}
 
 	return answer
 }
 
-func transferKey(data []byte, reader *bufio.Reader) {
-	oldKey := data[secretKeyOffset : secretKeyOffset+secretKeyLength]
+// transferKey returns the file the key was copied from, or "".
+func transferKey(dump *vcu.Dump, reader *bufio.Reader) string {
+	oldKey := dump.Key()
 
 	fmt.Print("🔑 Old key (hex): ")
 	for _, b := range oldKey {
@@ -188,20 +190,21 @@ func transferKey(data []byte, reader *bufio.Reader) {
 	transfer = strings.ToLower(strings.TrimSpace(transfer))
 
 	if transfer == "y" {
-		SetUidKey(data, reader)
+		return SetUidKey(dump, reader)
 	}
+	return ""
 }
 
 func printKeys() {
 	binFiles := getBinFiles(".")
 	for _, f := range binFiles {
-		d, err := os.ReadFile(f)
+		d, err := vcu.Load(f)
 		if err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "❌ Error reading file:", err)
-			os.Exit(1)
+			_, _ = fmt.Fprintf(os.Stderr, "\n\n%s\n❌ Error reading file: %v", f, err)
+			continue
 		}
 		fmt.Printf("\n\n%s", f)
-		oldKey := d[secretKeyOffset : secretKeyOffset+secretKeyLength]
+		oldKey := d.Key()
 
 		fmt.Print("\n🔑 Old key (hex): ")
 		for _, b := range oldKey {
@@ -255,44 +258,22 @@ func getBinFiles(dir string) []string {
 
 	var binFiles []string
 	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(strings.ToLower(f.Name()), ".bin") {
+		if !f.IsDir() && isDumpName(f.Name()) {
 			binFiles = append(binFiles, f.Name())
 		}
 	}
 	return binFiles
 }
 
-func readByteAt(buf []byte, offset int) (byte, error) {
-	if offset >= len(buf) {
-		return 0, fmt.Errorf("offset out of bounds")
-	}
-	return buf[offset], nil
-}
-
-func writeByteAt(buf []byte, offset int, value byte) error {
-	if offset >= len(buf) {
-		return fmt.Errorf("offset out of bounds")
-	}
-	buf[offset] = value
-	return nil
-}
-
 func findFirstBinFile() (string, error) {
 	entries, err := os.ReadDir(".")
 	if err != nil {
 		return "", err
 	}
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), ".bin") {
+		if !entry.IsDir() && isDumpName(entry.Name()) && !isPatchedName(entry.Name()) {
 			return entry.Name(), nil
 		}
 	}
-	return "", fmt.Errorf("no .bin file found in current directory")
-}
-
-func readUint16At(buf []byte, offset int) (uint16, error) {
-	if offset+2 > len(buf) {
-		return 0, fmt.Errorf("offset out of bounds")
-	}
-	return binary.LittleEndian.Uint16(buf[offset : offset+2]), nil
+	return "", fmt.Errorf("no dump file found in current directory")
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"change_sn/ninebot"
+)
+
+// liveSample is one poll of the telemetry registers. Values that could not
+// be read are nil.
+type liveSample struct {
+	Time       time.Time `json:"time"`
+	Speed      *float64  `json:"speed_kmh"`
+	SpeedLimit *int      `json:"speed_limit"`
+	Battery    *int      `json:"battery_pct"`
+	Mileage    *float64  `json:"mileage_km"`
+	ErrorCode  *int      `json:"error_code"`
+}
+
+// empty reports whether no register answered.
+func (s *liveSample) empty() bool {
+	return s.Speed == nil && s.SpeedLimit == nil && s.Battery == nil && s.Mileage == nil && s.ErrorCode == nil
+}
+
+// sampleWriter writes samples as CSV or JSON lines, flushing each one so a
+// crash or a pulled cable loses nothing already recorded.
+type sampleWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func newSampleWriter(w io.Writer, format string) (*sampleWriter, error) {
+	if format == formatJSONLines {
+		return &sampleWriter{json: json.NewEncoder(w)}, nil
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "speed_kmh", "speed_limit", "battery_pct", "mileage_km", "error_code"}); err != nil {
+		return nil, err
+	}
+	cw.Flush()
+	return &sampleWriter{csv: cw}, cw.Error()
+}
+
+func (w *sampleWriter) write(s *liveSample) error {
+	if w.json != nil {
+		return w.json.Encode(s)
+	}
+	_ = w.csv.Write([]string{
+		s.Time.Format(time.RFC3339Nano),
+		optionalFloat(s.Speed),
+		optionalInt(s.SpeedLimit),
+		optionalInt(s.Battery),
+		optionalFloat(s.Mileage),
+		optionalInt(s.ErrorCode),
+	})
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func optionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func optionalFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', 1, 64)
+}
+
+// readSample polls the telemetry registers. Reads that time out are left
+// empty and counted in dropped; any other error means the connection is
+// gone.
+func readSample(c *ninebot.Client, dropped *int) (*liveSample, error) {
+	s := &liveSample{Time: time.Now()}
+	read := func(index byte, n int, store func([]byte)) error {
+		b, err := c.ReadRegister(ninebot.AddrESC, index, n)
+		switch {
+		case errors.Is(err, ninebot.ErrTimeout), errors.Is(err, ninebot.ErrResponse):
+			*dropped++
+			return nil
+		case err != nil:
+			return err
+		}
+		store(b)
+		return nil
+	}
+	u16 := func(b []byte) int { return int(binary.LittleEndian.Uint16(b)) }
+
+	for _, r := range []struct {
+		index byte
+		n     int
+		store func([]byte)
+	}{
+		{ninebot.RegSpeed, 2, func(b []byte) { v := float64(int16(u16(b))) / 10; s.Speed = &v }},
+		{ninebot.RegSpeedLimit, 2, func(b []byte) { v := u16(b) / 10; s.SpeedLimit = &v }},
+		{ninebot.RegBattery, 2, func(b []byte) { v := u16(b); s.Battery = &v }},
+		{ninebot.RegTotalMileage, 4, func(b []byte) { v := float64(binary.LittleEndian.Uint32(b)) / 1000; s.Mileage = &v }},
+		{ninebot.RegErrorCode, 2, func(b []byte) { v := u16(b); s.ErrorCode = &v }},
+	} {
+		if err := read(r.index, r.n, r.store); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// runLiveRecord polls the telemetry registers until interrupted and logs
+// each sample. Lost frames leave gaps; a lost port is reopened.
+func runLiveRecord(args []string) error {
+	flags := newFlagSet("live")
+	port := flags.String("port", "", "serial port of the scooter bus, e.g. /dev/ttyUSB0")
+	baud := flags.Int("baud", ninebot.DefaultBaud, "baud rate")
+	interval := flags.Duration("interval", 500*time.Millisecond, "time between samples")
+	duration := flags.Duration("duration", 0, "stop after this long (default: until Ctrl+C)")
+	out := flags.String("out", "", "log file (default: standard output)")
+	overwrite := flags.Bool("overwrite", false, "replace an existing log file")
+	format := flags.String("format", "", "log format: csv or jsonl (default: from the --out extension, else csv)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatCSV
+		if ext := strings.ToLower(*out); strings.HasSuffix(ext, ".jsonl") || strings.HasSuffix(ext, ".json") {
+			*format = formatJSONLines
+		}
+	}
+	switch {
+	case *port == "":
+		return usageErrorf("missing --port")
+	case *interval <= 0:
+		return usageErrorf("--interval must be positive")
+	case *format != formatCSV && *format != formatJSONLines:
+		return usageErrorf("unknown format %q (want csv or jsonl)", *format)
+	}
+
+	// Progress goes to stderr when the log is written to stdout.
+	w, status := io.Writer(os.Stdout), io.Writer(os.Stdout)
+	if *out == "" {
+		status = os.Stderr
+	} else {
+		mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if *overwrite {
+			mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(*out, mode, 0o644)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		w = f
+	}
+	samples, err := newSampleWriter(w, *format)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	var (
+		c                        *ninebot.Client
+		count, dropped, connects int
+		topSpeed                 float64
+		limit                    *int
+		openErr                  error
+	)
+	defer func() {
+		if c != nil {
+			_ = c.Close()
+		}
+	}()
+	_, _ = fmt.Fprintln(status, "📍 Recording", *port, "every", *interval, "- press Ctrl+C to stop")
+	for wait := time.Duration(0); ; {
+		select {
+		case <-ctx.Done():
+			_, _ = fmt.Fprintf(status, "✅ %d sample(s) recorded, %d read(s) dropped, %d reconnect(s)\n", count, dropped, max(connects-1, 0))
+			if limit != nil {
+				_, _ = fmt.Fprintf(status, "🚀 Top speed %.1f km/h, speed limit %d\n", topSpeed, *limit)
+			}
+			if *out != "" {
+				_, _ = fmt.Fprintln(status, "💾 Log written to:", *out)
+			}
+			if connects == 0 {
+				return openErr
+			}
+			return nil
+		case <-time.After(wait):
+		}
+		wait = *interval
+
+		if c == nil {
+			if c, err = ninebot.Open(*port, *baud); err != nil {
+				// Warn once per outage, not on every retry.
+				if openErr == nil {
+					_, _ = fmt.Fprintln(status, "⚠️ Cannot open", *port+":", err, "- retrying")
+				}
+				openErr = err
+				wait = max(*interval, time.Second)
+				continue
+			}
+			openErr = nil
+			connects++
+			_, _ = fmt.Fprintln(status, "✅ Connected to", *port)
+		}
+
+		s, err := readSample(c, &dropped)
+		if err != nil {
+			_, _ = fmt.Fprintln(status, "⚠️ Connection lost:", err)
+			_ = c.Close()
+			c = nil
+			continue
+		}
+		if s.empty() {
+			continue
+		}
+		if err = samples.write(s); err != nil {
+			return err
+		}
+		count++
+		if s.Speed != nil {
+			topSpeed = max(topSpeed, *s.Speed)
+		}
+		if s.SpeedLimit != nil {
+			limit = s.SpeedLimit
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"change_sn/vcu"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatCSV  = "csv"
+	// formatJSONLines is one JSON object per line, for streamed logs.
+	formatJSONLines = "jsonl"
+)
+
+type serialReport struct {
+	Offset int    `json:"offset" yaml:"offset"`
+	Value  string `json:"value" yaml:"value"`
+}
+
+// dumpReport is the machine-readable summary of a dump used by the
+// verify, info and keys outputs.
+type dumpReport struct {
+	File        string         `json:"file" yaml:"file"`
+	Size        int            `json:"size" yaml:"size"`
+	SizeValid   bool           `json:"size_valid" yaml:"size_valid"`
+	Partial     bool           `json:"partial" yaml:"partial"`
+	HeaderValid bool           `json:"header_valid" yaml:"header_valid"`
+	Serials     []serialReport `json:"serials" yaml:"serials"`
+	MileageA    uint16         `json:"mileage_a" yaml:"mileage_a"`
+	MileageB    uint16         `json:"mileage_b" yaml:"mileage_b"`
+	Speeds      []int          `json:"speeds" yaml:"speeds"`
+	KeyHex      string         `json:"key_hex" yaml:"key_hex"`
+	KeyBase64   string         `json:"key_base64" yaml:"key_base64"`
+	Firmware    firmwareReport `json:"firmware" yaml:"firmware"`
+	Layout      string         `json:"layout" yaml:"layout"`
+}
+
+type firmwareReport struct {
+	Version     string `json:"version" yaml:"version"`
+	Method      string `json:"method" yaml:"method"`
+	AppSHA256   string `json:"app_sha256" yaml:"app_sha256"`
+	LayoutKnown bool   `json:"layout_known" yaml:"layout_known"`
+	// Verified is set when the version comes from a known build hash
+	// rather than a version string.
+	Verified bool `json:"verified" yaml:"verified"`
+}
+
+// err returns the vcu error matching the first failed check.
+func (r *dumpReport) err() error {
+	switch {
+	case !r.SizeValid:
+		return fmt.Errorf("%s: %w: got %d bytes, want %d or %d", r.File, vcu.ErrSize, r.Size, vcu.DumpSize, vcu.ConfigSize)
+	case !r.HeaderValid && !r.Partial:
+		return fmt.Errorf("%s: %w", r.File, vcu.ErrHeader)
+	}
+	return nil
+}
+
+// newReport describes data. Fields are only filled when the size matches a
+// full or config-only dump, since their offsets are meaningless otherwise.
+// A nil layout keeps the one selected by vcu.Parse.
+func newReport(file string, data []byte, layout *vcu.Layout) dumpReport {
+	r := dumpReport{
+		File:        file,
+		Size:        len(data),
+		HeaderValid: vcu.HasValidHeader(data),
+		Serials:     []serialReport{},
+		Speeds:      []int{},
+	}
+
+	dump, err := vcu.Parse(data)
+	if err != nil {
+		return r
+	}
+	r.SizeValid = true
+	r.Partial = dump.Partial()
+	if layout != nil {
+		dump.SetLayout(layout)
+	}
+	r.Layout = dump.Layout().Name
+
+	for _, sn := range dump.Serials() {
+		r.Serials = append(r.Serials, serialReport{Offset: sn.Offset, Value: sn.Value})
+	}
+	r.MileageA, r.MileageB = dump.Mileage()
+	for _, s := range dump.Speeds() {
+		r.Speeds = append(r.Speeds, int(s))
+	}
+	key := dump.Key()
+	r.KeyHex = strings.ToUpper(hex.EncodeToString(key))
+	r.KeyBase64 = base64.StdEncoding.EncodeToString(key)
+
+	fw := dump.DetectFirmware()
+	r.Firmware = firmwareReport{
+		Version:     fw.Version,
+		Method:      fw.Method,
+		AppSHA256:   fw.AppSHA256,
+		LayoutKnown: fw.Supported(),
+		Verified:    fw.Verified(),
+	}
+	return r
+}
+
+func readReport(file string, layout *vcu.Layout) (dumpReport, error) {
+	data, _, err := vcu.ReadImage(file)
+	if err != nil {
+		return dumpReport{}, err
+	}
+	return newReport(file, data, layout), nil
+}
+
+func checkFormat(format string) error {
+	switch format {
+	case formatText, formatJSON, formatYAML, formatCSV:
+		return nil
+	}
+	return usageErrorf("unknown format %q (want text, json, yaml or csv)", format)
+}
+
+// writeReports prints reports in format. JSON and YAML emit a single object
+// when list is false and an array otherwise.
+func writeReports(w io.Writer, format string, reports []dumpReport, list bool) error {
+	var v any = reports
+	if !list && len(reports) == 1 {
+		v = reports[0]
+	}
+
+	switch format {
+	case formatJSON, formatYAML:
+		return writeValue(w, format, v)
+	case formatCSV:
+		return writeReportsCSV(w, reports)
+	}
+
+	for _, r := range reports {
+		writeReportText(w, r)
+	}
+	return nil
+}
+
+// writeValue encodes v as indented JSON or YAML.
+func writeValue(w io.Writer, format string, v any) error {
+	if format == formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func writeReportsCSV(w io.Writer, reports []dumpReport) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{
+		"file", "size", "size_valid", "header_valid", "serials",
+		"mileage_a", "mileage_b", "speeds", "key_hex", "key_base64",
+		"firmware", "firmware_method", "app_sha256", "layout_known", "firmware_verified", "layout",
+		"partial",
+	})
+	for _, r := range reports {
+		serials := make([]string, 0, len(r.Serials))
+		for _, sn := range r.Serials {
+			serials = append(serials, fmt.Sprintf("%s@0x%05X", sn.Value, sn.Offset))
+		}
+		speeds := make([]string, 0, len(r.Speeds))
+		for _, s := range r.Speeds {
+			speeds = append(speeds, strconv.Itoa(s))
+		}
+		_ = cw.Write([]string{
+			r.File,
+			strconv.Itoa(r.Size),
+			strconv.FormatBool(r.SizeValid),
+			strconv.FormatBool(r.HeaderValid),
+			strings.Join(serials, ";"),
+			strconv.Itoa(int(r.MileageA)),
+			strconv.Itoa(int(r.MileageB)),
+			strings.Join(speeds, ";"),
+			r.KeyHex,
+			r.KeyBase64,
+			r.Firmware.Version,
+			r.Firmware.Method,
+			r.Firmware.AppSHA256,
+			strconv.FormatBool(r.Firmware.LayoutKnown),
+			strconv.FormatBool(r.Firmware.Verified),
+			r.Layout,
+			strconv.FormatBool(r.Partial),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeReportText(w io.Writer, r dumpReport) {
+	_, _ = fmt.Fprintf(w, "\n%s\n", r.File)
+	if !r.SizeValid {
+		_, _ = fmt.Fprintf(w, "❌ File corrupted: %d bytes\n", r.Size)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "✅ Len correct: %d\n", r.Size)
+	switch {
+	case r.Partial:
+		_, _ = fmt.Fprintln(w, "⚠️ Config-only dump (0x1F000–0x1FFFF). No header to check")
+	case r.HeaderValid:
+		_, _ = fmt.Fprintln(w, "✅ VALID header signature. Dump seems to be correct")
+	default:
+		_, _ = fmt.Fprintln(w, "❌ invalid header signature. File corrupted")
+	}
+	writeFirmwareText(w, r.Firmware)
+	_, _ = fmt.Fprintf(w, "   Layout profile: %s\n", r.Layout)
+
+	_, _ = fmt.Fprintln(w, "Found serial numbers:")
+	for _, sn := range r.Serials {
+		_, _ = fmt.Fprintf(w, "-> %s (0x%05X)\n", sn.Value, sn.Offset)
+	}
+	_, _ = fmt.Fprintf(w, "🚗 Current mileage A: %d (%.1f km)\n", r.MileageA, float64(r.MileageA)/10.0)
+	_, _ = fmt.Fprintf(w, "🚗 Current mileage B: %d (%.1f km)\n", r.MileageB, float64(r.MileageB)/10.0)
+	_, _ = fmt.Fprintln(w, "🚀 Current speed values:")
+	for _, val := range r.Speeds {
+		_, _ = fmt.Fprintf(w, "-> %d (0x%02X)\n", val, val)
+	}
+	_, _ = fmt.Fprintf(w, "🔑 Key (hex): %s\n", spacedHex(r.KeyHex))
+	_, _ = fmt.Fprintf(w, "📦 Key (base64): %s\n", r.KeyBase64)
+}
+
+// spacedHex turns "0A1B" into "0A 1B".
+func spacedHex(s string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(s); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s[i : i+2])
+	}
+	return b.String()
+}
+
+func writeFirmwareText(w io.Writer, fw firmwareReport) {
+	switch {
+	case fw.Version == "":
+		_, _ = fmt.Fprintln(w, "⚠️ Firmware version not detected. Field offsets may be wrong")
+	case fw.LayoutKnown && fw.Verified:
+		_, _ = fmt.Fprintf(w, "✅ Firmware %s (known build)\n", fw.Version)
+	case fw.LayoutKnown:
+		_, _ = fmt.Fprintf(w, "⚠️ Firmware %s (from its version string, not a known build). Field offsets are unverified\n", fw.Version)
+	default:
+		_, _ = fmt.Fprintf(w, "⚠️ Firmware %s (detected by %s) is not tested. Field offsets may be wrong\n", fw.Version, fw.Method)
+	}
+	_, _ = fmt.Fprintf(w, "   App SHA-256: %s\n", fw.AppSHA256)
+}
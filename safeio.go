@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"change_sn/vcu"
+)
+
+var errExists = errors.New("output file already exists (use --overwrite to replace it)")
+
+// writeFileAtomic writes data to a temporary file next to path and moves
+// it into place, so an interrupted write never leaves a truncated dump.
+// Unless overwrite is set an existing file is never replaced: the file is
+// hard-linked into place, which fails if path exists, even when it was
+// created after the call started.
+func writeFileAtomic(path string, data []byte, overwrite bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if overwrite {
+		return os.Rename(tmp.Name(), path)
+	}
+
+	err = os.Link(tmp.Name(), path)
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		return &fs.PathError{Op: "write", Path: path, Err: errExists}
+	case err != nil:
+		// File systems without hard links, such as FAT, still get the
+		// no-overwrite guarantee, but not the atomic write.
+		return writeFileExclusive(path, data)
+	}
+	return nil
+}
+
+// writeFileExclusive creates path and writes data to it, failing if path
+// exists.
+func writeFileExclusive(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return &fs.PathError{Op: "write", Path: path, Err: errExists}
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// nextFreeName returns name, or name with ".1", ".2", ... inserted before
+// the extension, whichever is the first that neither exists nor has a
+// change journal.
+func nextFreeName(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s.%d%s", base, i, ext)
+		}
+		if !exists(candidate) && !exists(vcu.JournalPath(candidate)) {
+			return candidate
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// isPatchedName reports whether name looks like an output of this tool.
+func isPatchedName(name string) bool {
+	return strings.Contains(strings.ToLower(name), ".patched.") ||
+		strings.Contains(strings.ToLower(name), ".restored.")
+}
+
+// outputName returns the default name for a file derived from in, such as
+// a patched or restored dump. It keeps the image format of in, so a patched
+// .hex dump is written as .hex again.
+func outputName(in, suffix string) string {
+	ext := filepath.Ext(in)
+	if vcu.FormatForPath(in) == "" {
+		ext = ".bin"
+	}
+	return nextFreeName(in + "." + suffix + ext)
+}
+
+// writeImage encodes dump in the format implied by the extension of path,
+// raw binary by default, and writes it with writeFileAtomic.
+func writeImage(path string, dump *vcu.Dump, overwrite bool) error {
+	format := vcu.FormatForPath(path)
+	if format == "" {
+		format = vcu.FormatBinary
+	}
+	data, err := dump.Encode(format)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, overwrite)
+}
+
+// isDumpName reports whether name has the extension of a supported image
+// format.
+func isDumpName(name string) bool {
+	return vcu.FormatForPath(name) != ""
+}
+
+// backupFile copies the file at path byte for byte to
+// <path>.<timestamp>.bak and returns the name.
+func backupFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	name := nextFreeName(fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405")))
+	return name, writeFileAtomic(name, data, false)
+}
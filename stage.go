@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"change_sn/vcu"
+)
+
+// runStage puts an application into the staging area of a dump and arms
+// the update descriptor, so the VCU's own bootloader installs it on the
+// next boot. A firmware with another config layout is refused unless
+// forced.
+func runStage(args []string) error {
+	flags := newFlagSet("stage")
+	dumpFile := flags.String("dump", "", "dump file to stage the update in")
+	appName := flags.String("app", "", "application image, dump file or template version to stage")
+	force := flags.Bool("force", false, "stage even if the new firmware uses another config layout")
+	output := addOutputFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	dump, err := loadDump(*dumpFile)
+	if err != nil {
+		return err
+	}
+	app, err := loadApp(*appName)
+	if err != nil {
+		return err
+	}
+	original := dump.Clone()
+	if err = dump.Stage(app); err != nil {
+		return err
+	}
+
+	running, staged := dump.DetectFirmware(), dump.StagedFirmware()
+	fmt.Printf("📦 Staged %d bytes of firmware %s at 0x%08X, running firmware %s\n",
+		len(app), staged, vcu.FlashBase+vcu.StagingOffset, running)
+	if staged.AppSHA256 == running.AppSHA256 {
+		fmt.Println("⚠️ The staged image is the application already running")
+	}
+	if err = checkStage(dump, running, staged, *force); err != nil {
+		return err
+	}
+	writeDescriptorText(os.Stdout, newDescriptorReport(*dumpFile, dump))
+
+	out := *output.out
+	if out == "" {
+		out = outputName(*dumpFile, "staged")
+	}
+	return writeDump(out, original, dump, saveOptions{
+		in:        *dumpFile,
+		backup:    true,
+		overwrite: *output.overwrite,
+	})
+}
+
+// loadApp returns the application image in name: a bare application, a
+// full dump or a template version to take the application from.
+func loadApp(name string) ([]byte, error) {
+	if name == "" {
+		return nil, usageErrorf("missing --app")
+	}
+	if _, err := os.Stat(name); err == nil {
+		data, _, err := vcu.ReadImage(name)
+		if err != nil {
+			return nil, err
+		}
+		app, err := vcu.AppImage(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return app, nil
+	}
+
+	template, err := loadTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	region, _ := vcu.LookupRegion(vcu.RegionApp)
+	return vcu.AppImage(template.RegionBytes(region))
+}
+
+// layoutFor finds layout profiles for checkStage. Tests replace it rather
+// than registering profiles globally.
+var layoutFor = vcu.LayoutFor
+
+// checkStage refuses a staged firmware that reads the config pages with
+// another layout than the running one, unless force is set: the config
+// pages are kept, so they must suit the new firmware too.
+func checkStage(dump *vcu.Dump, running, staged vcu.Firmware, force bool) error {
+	current, okCurrent := layoutFor(running.Version, dump.BootSHA256())
+	next, okNext := layoutFor(staged.Version, "")
+	switch {
+	case !okCurrent:
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ No layout known for the running firmware %s, the config cannot be checked\n", running)
+	case !okNext:
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ No layout known for firmware %s, the config cannot be checked\n", staged)
+	case current.Name == next.Name:
+	case force:
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ Firmware %s uses layout %s but the config was written for layout %s\n", staged, next.Name, current.Name)
+	default:
+		return fmt.Errorf("%w: firmware %s (%s) and %s (%s) (use --force to stage anyway)", errIncompatible, running, current.Name, staged, next.Name)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"change_sn/vcu"
+)
+
+func TestCheckStage(t *testing.T) {
+	other := *vcu.DefaultLayout
+	other.Name, other.Versions = "stage-test", []string{"9.9.1"}
+	layoutFor = func(version, bootSHA256 string) (*vcu.Layout, bool) {
+		if version == "9.9.1" {
+			return &other, true
+		}
+		return vcu.LayoutFor(version, bootSHA256)
+	}
+	t.Cleanup(func() {
+		layoutFor = vcu.LayoutFor
+	})
+	dump, err := loadDump(writeTestDump(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running := vcu.Firmware{Version: "1.5.5", Method: "test"}
+	for _, tt := range []struct {
+		name   string
+		staged string
+		force  bool
+		want   error
+	}{
+		{"same layout", "1.5.6", false, nil},
+		{"unknown layout", "9.9.9", false, nil},
+		{"other layout", "9.9.1", false, errIncompatible},
+		{"other layout, forced", "9.9.1", true, nil},
+	} {
+		err := checkStage(dump, running, vcu.Firmware{Version: tt.staged, Method: "test"}, tt.force)
+		if !errors.Is(err, tt.want) || tt.want == nil && err != nil {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+// Package stm32boot implements the USART protocol of the STM32 system
+// memory bootloader (ST application note AN3155). It is the way back into
+// a VCU whose firmware no longer boots: with BOOT0 held high the ROM
+// bootloader answers on the UART even when the flash is empty or broken.
+package stm32boot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"change_sn/vcu"
+	"go.bug.st/serial"
+)
+
+// DefaultBaud is a rate every STM32 bootloader autodetects reliably.
+const DefaultBaud = 115200
+
+// Protocol bytes.
+const (
+	ack  = 0x79
+	nack = 0x1F
+	sync = 0x7F
+)
+
+// Bootloader commands.
+const (
+	CmdGet              = 0x00
+	CmdGetVersion       = 0x01
+	CmdGetID            = 0x02
+	CmdReadMemory       = 0x11
+	CmdGo               = 0x21
+	CmdWriteMemory      = 0x31
+	CmdErase            = 0x43
+	CmdExtendedErase    = 0x44
+	CmdReadoutProtect   = 0x82
+	CmdReadoutUnprotect = 0x92
+)
+
+// maxTransfer is the largest block Read Memory and Write Memory accept.
+const maxTransfer = 256
+
+// The option bytes of the STM32F1. Readout protection is off only while
+// the RDP byte holds rdpOff; any other value turns it on at the next reset.
+const (
+	OptionBytes = 0x1FFFF800
+	rdpOff      = 0xA5
+)
+
+var (
+	ErrNACK        = errors.New("stm32boot: command rejected")
+	ErrTimeout     = errors.New("stm32boot: no answer from bootloader")
+	ErrProtected   = errors.New("stm32boot: flash is readout protected")
+	ErrUnsupported = errors.New("stm32boot: command not supported by this bootloader")
+)
+
+// Client talks to the bootloader over a serial port. It implements
+// vcu.Target.
+type Client struct {
+	port io.ReadWriteCloser
+	// Version is the bootloader protocol version, e.g. 0x22 for 2.2.
+	Version byte
+	// Commands lists the command codes the bootloader supports.
+	Commands []byte
+	// Timeout bounds the wait for each answer; erases wait longer.
+	Timeout time.Duration
+}
+
+// Open opens a serial port with the 8E1 framing the bootloader uses and
+// connects to it.
+func Open(name string, baud int) (*Client, error) {
+	port, err := serial.Open(name, &serial.Mode{
+		BaudRate: baud,
+		DataBits: 8,
+		Parity:   serial.EvenParity,
+		StopBits: serial.OneStopBit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(port)
+	if err != nil {
+		_ = port.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient sends the 0x7F autobaud byte over port and reads the list of
+// supported commands.
+func NewClient(port io.ReadWriteCloser) (*Client, error) {
+	c := &Client{port: port, Timeout: time.Second}
+	if err := c.write(sync); err != nil {
+		return nil, err
+	}
+	// A bootloader that was already synchronised takes 0x7F as a command
+	// and waits for its complement. A second 0x7F is not that, so it
+	// answers NACK, which tells us it is alive.
+	_, err := c.readByte(c.Timeout)
+	if errors.Is(err, ErrTimeout) {
+		if err = c.write(sync); err != nil {
+			return nil, err
+		}
+		_, err = c.readByte(c.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the serial port. The VCU stays in the bootloader.
+func (c *Client) Close() error {
+	return c.port.Close()
+}
+
+func (c *Client) write(b ...byte) error {
+	_, err := c.port.Write(b)
+	return err
+}
+
+// readFull reads len(buf) bytes, giving up after timeout.
+func (c *Client) readFull(buf []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	switch p := c.port.(type) {
+	case serial.Port:
+		if err := p.SetReadTimeout(timeout); err != nil {
+			return err
+		}
+	case interface{ SetReadDeadline(time.Time) error }:
+		if err := p.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	for n := 0; n < len(buf); {
+		m, err := c.port.Read(buf[n:])
+		n += m
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, os.ErrDeadlineExceeded):
+			return ErrTimeout
+		case err != nil:
+			return err
+		case m == 0 || time.Now().After(deadline) && n < len(buf):
+			return ErrTimeout
+		}
+	}
+	return nil
+}
+
+func (c *Client) readByte(timeout time.Duration) (byte, error) {
+	b := make([]byte, 1)
+	err := c.readFull(b, timeout)
+	return b[0], err
+}
+
+// waitAck reads one byte and checks it is an ACK.
+func (c *Client) waitAck(timeout time.Duration) error {
+	b, err := c.readByte(timeout)
+	switch {
+	case err != nil:
+		return err
+	case b == nack:
+		return ErrNACK
+	case b != ack:
+		return fmt.Errorf("%w: got 0x%02X instead of ACK", ErrNACK, b)
+	}
+	return nil
+}
+
+// command sends a command code with its complement and waits for the ACK.
+func (c *Client) command(cmd byte) error {
+	if c.Commands != nil && !slices.Contains(c.Commands, cmd) {
+		return fmt.Errorf("%w: 0x%02X", ErrUnsupported, cmd)
+	}
+	if err := c.write(cmd, ^cmd); err != nil {
+		return err
+	}
+	if err := c.waitAck(c.Timeout); err != nil {
+		return fmt.Errorf("command 0x%02X: %w", cmd, err)
+	}
+	return nil
+}
+
+// withChecksum appends the XOR of data.
+func withChecksum(data ...byte) []byte {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+	return append(data, sum)
+}
+
+func (c *Client) sendAddress(addr uint32) error {
+	if err := c.write(withChecksum(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))...); err != nil {
+		return err
+	}
+	return c.waitAck(c.Timeout)
+}
+
+// get runs the Get command.
+func (c *Client) get() error {
+	if err := c.command(CmdGet); err != nil {
+		return err
+	}
+	n, err := c.readByte(c.Timeout)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, int(n)+1)
+	if err = c.readFull(buf, c.Timeout); err != nil {
+		return err
+	}
+	c.Version, c.Commands = buf[0], buf[1:]
+	return c.waitAck(c.Timeout)
+}
+
+// GetID returns the product ID of the chip, e.g. 0x410 for an STM32F103
+// medium-density part.
+func (c *Client) GetID() (uint16, error) {
+	if err := c.command(CmdGetID); err != nil {
+		return 0, err
+	}
+	n, err := c.readByte(c.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, int(n)+1)
+	if err = c.readFull(buf, c.Timeout); err != nil {
+		return 0, err
+	}
+	var id uint16
+	for _, b := range buf {
+		id = id<<8 | uint16(b)
+	}
+	return id, c.waitAck(c.Timeout)
+}
+
+// GetVersion returns the bootloader version and the two option bytes that
+// reflect the readout protection state.
+func (c *Client) GetVersion() (version, option1, option2 byte, err error) {
+	if err = c.command(CmdGetVersion); err != nil {
+		return 0, 0, 0, err
+	}
+	buf := make([]byte, 3)
+	if err = c.readFull(buf, c.Timeout); err != nil {
+		return 0, 0, 0, err
+	}
+	return buf[0], buf[1], buf[2], c.waitAck(c.Timeout)
+}
+
+// ReadoutUnprotect removes readout protection. The bootloader mass erases
+// the flash, including the config sector, and resets the chip, so the
+// client has to reconnect afterwards.
+func (c *Client) ReadoutUnprotect() error {
+	if err := c.command(CmdReadoutUnprotect); err != nil {
+		return err
+	}
+	return c.waitAck(30 * time.Second)
+}
+
+// ReadoutProtect turns readout protection on. The bootloader resets the
+// chip afterwards, so the client has to reconnect.
+func (c *Client) ReadoutProtect() error {
+	if err := c.command(CmdReadoutProtect); err != nil {
+		return err
+	}
+	return c.waitAck(c.Timeout)
+}
+
+// ReadoutProtected reports whether readout protection is active or set to
+// become active at the next reset. While it is active the bootloader
+// rejects the Read Memory command itself; otherwise the RDP option byte
+// tells.
+func (c *Client) ReadoutProtected() (bool, error) {
+	b, err := c.ReadMemory(OptionBytes, 1)
+	switch {
+	case errors.Is(err, ErrProtected):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return b[0] != rdpOff, nil
+}
+
+// ReadMemory reads n bytes at the absolute address addr. It returns
+// ErrProtected when the bootloader rejects the command, which it does only
+// while readout protection is active; a rejected address is an ErrNACK.
+func (c *Client) ReadMemory(addr uint32, n int) ([]byte, error) {
+	data := make([]byte, 0, n)
+	for len(data) < n {
+		count := min(maxTransfer, n-len(data))
+		a := addr + uint32(len(data))
+		if err := c.command(CmdReadMemory); errors.Is(err, ErrNACK) {
+			return nil, fmt.Errorf("read 0x%08X: %w", a, ErrProtected)
+		} else if err != nil {
+			return nil, err
+		}
+		if err := c.sendAddress(a); err != nil {
+			return nil, fmt.Errorf("read 0x%08X: %w", a, err)
+		}
+		if err := c.write(byte(count-1), ^byte(count-1)); err != nil {
+			return nil, err
+		}
+		if err := c.waitAck(c.Timeout); err != nil {
+			return nil, fmt.Errorf("read 0x%08X: %w", a, err)
+		}
+		buf := make([]byte, count)
+		if err := c.readFull(buf, c.Timeout); err != nil {
+			return nil, err
+		}
+		data = append(data, buf...)
+	}
+	return data, nil
+}
+
+// WriteMemory writes data at the absolute address addr. Flash must have
+// been erased first.
+func (c *Client) WriteMemory(addr uint32, data []byte) error {
+	for i := 0; i < len(data); i += maxTransfer {
+		block := data[i:min(i+maxTransfer, len(data))]
+		// The length must be a multiple of 4.
+		for len(block)%4 != 0 {
+			block = append(slices.Clip(block), 0xFF)
+		}
+		a := addr + uint32(i)
+		if err := c.command(CmdWriteMemory); err != nil {
+			return err
+		}
+		if err := c.sendAddress(a); err != nil {
+			return fmt.Errorf("write 0x%08X: %w", a, err)
+		}
+		if err := c.write(withChecksum(append([]byte{byte(len(block) - 1)}, block...)...)...); err != nil {
+			return err
+		}
+		if err := c.waitAck(c.Timeout); err != nil {
+			return fmt.Errorf("write 0x%08X: %w", a, err)
+		}
+	}
+	return nil
+}
+
+// ErasePages erases the given flash pages, using Extended Erase on
+// bootloaders that have it.
+func (c *Client) ErasePages(pages ...int) error {
+	var req []byte
+	if slices.Contains(c.Commands, CmdExtendedErase) {
+		if err := c.command(CmdExtendedErase); err != nil {
+			return err
+		}
+		n := len(pages) - 1
+		req = []byte{byte(n >> 8), byte(n)}
+		for _, p := range pages {
+			req = append(req, byte(p>>8), byte(p))
+		}
+	} else {
+		if err := c.command(CmdErase); err != nil {
+			return err
+		}
+		req = []byte{byte(len(pages) - 1)}
+		for _, p := range pages {
+			req = append(req, byte(p))
+		}
+	}
+	if err := c.write(withChecksum(req...)...); err != nil {
+		return err
+	}
+	// Erasing takes up to 40 ms per page.
+	return c.waitAck(c.Timeout + time.Duration(len(pages))*100*time.Millisecond)
+}
+
+// Go jumps to the code at addr; the bootloader loads the stack pointer from
+// addr and the entry point from addr+4.
+func (c *Client) Go(addr uint32) error {
+	if err := c.command(CmdGo); err != nil {
+		return err
+	}
+	return c.sendAddress(addr)
+}
+
+// ReadFlash reads n bytes of flash starting at offset.
+func (c *Client) ReadFlash(offset, n int) ([]byte, error) {
+	return c.ReadMemory(uint32(vcu.FlashBase+offset), n)
+}
+
+// ProgramPage erases the flash page at offset and programs data into it.
+// Blocks that are entirely erased are skipped.
+func (c *Client) ProgramPage(offset int, data []byte) error {
+	if err := c.ErasePages(offset / vcu.PageSize); err != nil {
+		return err
+	}
+	for i := 0; i < len(data); i += maxTransfer {
+		block := data[i:min(i+maxTransfer, len(data))]
+		if isErased(block) {
+			continue
+		}
+		if err := c.WriteMemory(uint32(vcu.FlashBase+offset+i), block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset starts the application in flash.
+func (c *Client) Reset() error {
+	return c.Go(vcu.FlashBase)
+}
+
+func isErased(b []byte) bool {
+	for _, v := range b {
+		if v != 0xFF {
+			return false
+		}
+	}
+	return true
+}
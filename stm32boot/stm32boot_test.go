@@ -0,0 +1,329 @@
+package stm32boot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	stdsync "sync"
+	"testing"
+
+	"change_sn/vcu"
+	"github.com/creack/pty"
+)
+
+// fakeBootloader emulates the ROM bootloader of an STM32F103 on the
+// master side of a pty. Flash behaves like NOR flash: erasing sets bytes
+// to 0xFF and writing can only clear bits.
+type fakeBootloader struct {
+	mu        stdsync.Mutex
+	flash     []byte
+	options   []byte // option bytes at OptionBytes
+	protected bool   // readout protection is active
+	commands  []byte
+
+	w *os.File
+	r *bufio.Reader
+}
+
+// startFakeBootloader serves a fake bootloader on a new pty and returns
+// the name of its terminal side.
+func startFakeBootloader(t *testing.T, f *fakeBootloader) string {
+	t.Helper()
+	master, tty, err := pty.Open()
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() {
+		_ = master.Close()
+		_ = tty.Close()
+	})
+	if f.flash == nil {
+		f.flash = bytes.Repeat([]byte{0xFF}, vcu.DumpSize)
+	}
+	if f.options == nil {
+		f.options = []byte{rdpOff, ^byte(rdpOff), 0xFF, 0x00}
+	}
+	if f.commands == nil {
+		f.commands = []byte{CmdGet, CmdGetVersion, CmdGetID, CmdReadMemory, CmdGo, CmdWriteMemory, CmdErase, CmdReadoutProtect, CmdReadoutUnprotect}
+	}
+	f.w, f.r = master, bufio.NewReader(master)
+	go f.serve()
+	return tty.Name()
+}
+
+func (f *fakeBootloader) reply(b ...byte) {
+	_, _ = f.w.Write(b)
+}
+
+func (f *fakeBootloader) read(n int) ([]byte, bool) {
+	buf := make([]byte, n)
+	for i := range buf {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return nil, false
+		}
+		buf[i] = b
+	}
+	return buf, true
+}
+
+// readChecked reads n bytes followed by their XOR checksum.
+func (f *fakeBootloader) readChecked(n int) ([]byte, bool) {
+	buf, ok := f.read(n + 1)
+	if !ok {
+		return nil, false
+	}
+	var sum byte
+	for _, b := range buf[:n] {
+		sum ^= b
+	}
+	if n == 1 {
+		sum = ^sum // single bytes are sent with their complement
+	}
+	return buf[:n], sum == buf[n]
+}
+
+// memory returns the memory at addr, or nil for an unmapped address.
+func (f *fakeBootloader) memory(addr uint32, n int) []byte {
+	switch {
+	case addr >= vcu.FlashBase && int(addr-vcu.FlashBase)+n <= len(f.flash):
+		return f.flash[addr-vcu.FlashBase : int(addr-vcu.FlashBase)+n]
+	case addr >= OptionBytes && int(addr-OptionBytes)+n <= len(f.options):
+		return f.options[addr-OptionBytes : int(addr-OptionBytes)+n]
+	}
+	return nil
+}
+
+func (f *fakeBootloader) serve() {
+	if b, ok := f.read(1); !ok || b[0] != sync {
+		return
+	}
+	f.reply(ack)
+	for {
+		cmd, ok := f.read(2)
+		if !ok {
+			return
+		}
+		f.mu.Lock()
+		f.handle(cmd)
+		f.mu.Unlock()
+	}
+}
+
+func (f *fakeBootloader) handle(cmd []byte) {
+	if cmd[1] != ^cmd[0] || !slices.Contains(f.commands, cmd[0]) {
+		f.reply(nack)
+		return
+	}
+	address := func() (uint32, bool) {
+		a, ok := f.readChecked(4)
+		var addr uint32
+		for _, b := range a {
+			addr = addr<<8 | uint32(b)
+		}
+		if !ok || f.memory(addr, 1) == nil {
+			f.reply(nack)
+			return 0, false
+		}
+		f.reply(ack)
+		return addr, true
+	}
+
+	switch cmd[0] {
+	case CmdGet:
+		f.reply(append([]byte{ack, byte(len(f.commands)), 0x22}, append(f.commands, ack)...)...)
+	case CmdGetVersion:
+		f.reply(ack, 0x22, 0x00, 0x00, ack)
+	case CmdGetID:
+		f.reply(ack, 0x01, 0x04, 0x10, ack)
+	case CmdReadMemory:
+		if f.protected {
+			f.reply(nack)
+			return
+		}
+		f.reply(ack)
+		addr, ok := address()
+		if !ok {
+			return
+		}
+		n, ok := f.readChecked(1)
+		if !ok {
+			f.reply(nack)
+			return
+		}
+		mem := f.memory(addr, int(n[0])+1)
+		if mem == nil {
+			f.reply(nack)
+			return
+		}
+		f.reply(append([]byte{ack}, mem...)...)
+	case CmdWriteMemory:
+		if f.protected {
+			f.reply(nack)
+			return
+		}
+		f.reply(ack)
+		addr, ok := address()
+		if !ok {
+			return
+		}
+		n, ok := f.read(1)
+		if !ok {
+			return
+		}
+		data, ok := f.read(int(n[0]) + 2)
+		if !ok {
+			return
+		}
+		var sum byte
+		for _, b := range append(n, data[:len(data)-1]...) {
+			sum ^= b
+		}
+		mem := f.memory(addr, len(data)-1)
+		if sum != data[len(data)-1] || mem == nil {
+			f.reply(nack)
+			return
+		}
+		for i, b := range data[:len(data)-1] {
+			mem[i] &= b
+		}
+		f.reply(ack)
+	case CmdErase:
+		f.reply(ack)
+		n, ok := f.read(1)
+		if !ok {
+			return
+		}
+		pages, ok := f.read(int(n[0]) + 2)
+		if !ok {
+			return
+		}
+		for _, p := range pages[:len(pages)-1] {
+			clear255(f.flash[int(p)*vcu.PageSize : (int(p)+1)*vcu.PageSize])
+		}
+		f.reply(ack)
+	case CmdGo:
+		f.reply(ack)
+		address()
+	case CmdReadoutProtect:
+		f.options[0], f.options[1] = 0x00, 0xFF
+		f.reply(ack, ack)
+	case CmdReadoutUnprotect:
+		clear255(f.flash)
+		f.options[0], f.options[1] = rdpOff, ^byte(rdpOff)
+		f.protected = false
+		f.reply(ack, ack)
+	}
+}
+
+func clear255(b []byte) {
+	for i := range b {
+		b[i] = 0xFF
+	}
+}
+
+func testFlash() []byte {
+	flash := make([]byte, vcu.DumpSize)
+	for i := range flash {
+		flash[i] = byte(i * 13)
+	}
+	return flash
+}
+
+func open(t *testing.T, f *fakeBootloader) *Client {
+	t.Helper()
+	c, err := Open(startFakeBootloader(t, f), DefaultBaud)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestConnect(t *testing.T) {
+	c := open(t, &fakeBootloader{})
+	if c.Version != 0x22 || !slices.Contains(c.Commands, CmdReadMemory) {
+		t.Errorf("version 0x%02X, commands % X", c.Version, c.Commands)
+	}
+	if id, err := c.GetID(); err != nil || id != 0x410 {
+		t.Errorf("GetID = 0x%03X, %v, want 0x410", id, err)
+	}
+}
+
+func TestReadAndProgramFlash(t *testing.T) {
+	f := &fakeBootloader{flash: testFlash()}
+	c := open(t, f)
+
+	current, err := vcu.ReadTarget(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current.Bytes(), testFlash()[vcu.ConfigAOffset:]) {
+		t.Fatal("ReadTarget returned the wrong bytes")
+	}
+
+	patched := current.Clone()
+	patched.Bytes()[0x404] = 0xFF // needs an erase
+	patched.Bytes()[0x405] = 0x00
+	pages, err := vcu.WriteTarget(c, current, patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Errorf("wrote pages %X, want one", pages)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !bytes.Equal(f.flash[vcu.ConfigAOffset:], patched.Bytes()) {
+		t.Error("flash does not match the patched dump")
+	}
+}
+
+func TestReadoutProtected(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		rdp       byte
+		protected bool
+		want      bool
+	}{
+		{"off", rdpOff, false, false},
+		{"set, active after reset", 0x00, false, true},
+		{"active", 0x00, true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := open(t, &fakeBootloader{options: []byte{tt.rdp, ^tt.rdp, 0xFF, 0x00}, protected: tt.protected})
+			got, err := c.ReadoutProtected()
+			if err != nil || got != tt.want {
+				t.Errorf("ReadoutProtected = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	c := open(t, &fakeBootloader{})
+
+	// A bad address is rejected, but that is not readout protection.
+	_, err := c.ReadMemory(0x40000000, 4)
+	if !errors.Is(err, ErrNACK) || errors.Is(err, ErrProtected) {
+		t.Errorf("bad address: %v, want ErrNACK only", err)
+	}
+
+	if err = c.ReadoutProtect(); err != nil {
+		t.Fatal(err)
+	}
+	if protected, err := c.ReadoutProtected(); err != nil || !protected {
+		t.Errorf("after ReadoutProtect: %v, %v", protected, err)
+	}
+}
+
+func TestUnsupportedCommand(t *testing.T) {
+	c := open(t, &fakeBootloader{commands: []byte{CmdGet, CmdGetID, CmdReadMemory}})
+	if err := c.ReadoutProtect(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ReadoutProtect: %v, want ErrUnsupported", err)
+	}
+}
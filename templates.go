@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"change_sn/vcu"
+)
+
+// templateDir is the directory holding firmware templates and their
+// manifest.
+const templateDir = "DUMPS"
+
+// embeddedTemplates is set when the binary is built with the
+// embed_templates tag.
+var embeddedTemplates fs.FS
+
+// errNoTemplates means no template store has any templates, which is the
+// normal case for builds without embedded templates.
+var errNoTemplates = fmt.Errorf("no templates found in embedded templates or %s directories (see templates --generate)", templateDir)
+
+var (
+	templates    *vcu.TemplateStore
+	templatesErr error
+	templatesSet bool
+)
+
+// openTemplates returns the first template store with templates: the
+// embedded one, then DUMPS next to the executable, then DUMPS in the
+// current directory. A directory whose manifest is missing or empty serves
+// its .bin files with a version in their name instead. The result is
+// cached.
+func openTemplates() (*vcu.TemplateStore, error) {
+	if templatesSet {
+		return templates, templatesErr
+	}
+	templatesSet = true
+
+	var sources []fs.FS
+	if embeddedTemplates != nil {
+		sources = append(sources, embeddedTemplates)
+	}
+	if exe, err := os.Executable(); err == nil {
+		sources = append(sources, os.DirFS(filepath.Join(filepath.Dir(exe), templateDir)))
+	}
+	sources = append(sources, os.DirFS(templateDir))
+
+	templatesErr = errNoTemplates
+	for _, fsys := range sources {
+		store, err := vcu.OpenTemplates(fsys)
+		// The shipped manifest is empty until templates --generate runs.
+		if errors.Is(err, fs.ErrNotExist) || err == nil && len(store.Templates()) == 0 {
+			m, _, scanErr := scanTemplates(fsys)
+			if scanErr != nil || len(m.Templates) == 0 {
+				continue
+			}
+			store, err = vcu.NewTemplateStore(fsys, m), nil
+		}
+		templates, templatesErr = store, err
+		break
+	}
+	return templates, templatesErr
+}
+
+// loadTemplate loads a template by manifest version, or a dump file when
+// name is a path.
+func loadTemplate(name string) (*vcu.Dump, error) {
+	if _, err := os.Stat(name); err == nil {
+		return loadDump(name)
+	}
+
+	store, err := openTemplates()
+	if err != nil {
+		return nil, err
+	}
+	t, err := store.Find(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w (available: %s)", err, templateNames(store))
+	}
+	return store.Load(t)
+}
+
+// templateOutputBase returns the name a dump built from template is named
+// after: the base name of a template file, or MEMORY_G3_<version>.bin for a
+// manifest template, in the current directory either way.
+func templateOutputBase(template string) string {
+	if _, err := os.Stat(template); err == nil {
+		return strings.TrimSuffix(filepath.Base(template), filepath.Ext(template)) + ".bin"
+	}
+	return "MEMORY_G3_" + template + ".bin"
+}
+
+var templateVersionPattern = regexp.MustCompile(`_(\d+\.\d+\.\d+)`)
+
+// runTemplates lists the templates in the manifest, or with --generate
+// writes a manifest for the .bin files in a template directory.
+func runTemplates(args []string) error {
+	flags := newFlagSet("templates")
+	generate := flags.String("generate", "", "write "+vcu.ManifestName+" for the .bin files in this directory")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *generate != "" {
+		return generateManifest(*generate)
+	}
+
+	store, err := openTemplates()
+	if err != nil {
+		return err
+	}
+	for _, t := range store.Templates() {
+		fmt.Printf("%s: %s (layout %s)\n   sha256 %s\n", t.Version, t.File, t.Layout, t.SHA256)
+	}
+	return nil
+}
+
+// scanTemplates describes the .bin files at the root of fsys, taking each
+// template's version from the "_X.Y.Z" part of its file name. It returns
+// the .bin files without a version as skipped. Templates are raw images,
+// since the store parses them as such, so .hex and .s19 files are ignored.
+func scanTemplates(fsys fs.FS) (m vcu.Manifest, skipped []string, err error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return m, nil, err
+	}
+	for _, e := range entries {
+		f := e.Name()
+		if e.IsDir() || vcu.FormatForPath(f) != vcu.FormatBinary {
+			continue
+		}
+		match := templateVersionPattern.FindStringSubmatch(f)
+		if match == nil {
+			skipped = append(skipped, f)
+			continue
+		}
+		data, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return m, skipped, err
+		}
+		m.Templates = append(m.Templates, vcu.NewTemplate(match[1], f, data))
+	}
+	return m, skipped, nil
+}
+
+// generateManifest writes the manifest scanTemplates finds for dir.
+func generateManifest(dir string) error {
+	m, skipped, err := scanTemplates(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	for _, f := range skipped {
+		_, _ = fmt.Fprintf(os.Stderr, "⚠️ Skipping %s: no version in file name\n", f)
+	}
+	if len(m.Templates) == 0 {
+		return fmt.Errorf("no templates found in %s", dir)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	out := filepath.Join(dir, vcu.ManifestName)
+	if err = writeFileAtomic(out, append(data, '\n'), true); err != nil {
+		return err
+	}
+	fmt.Printf("✅ %d template(s) written to %s\n", len(m.Templates), out)
+	return nil
+}
+
+func templateNames(store *vcu.TemplateStore) string {
+	var names []string
+	for _, t := range store.Templates() {
+		names = append(names, t.Version)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,19 @@
+//go:build embed_templates
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed DUMPS
+var embeddedDumps embed.FS
+
+func init() {
+	sub, err := fs.Sub(embeddedDumps, templateDir)
+	if err != nil {
+		panic(err)
+	}
+	embeddedTemplates = sub
+}
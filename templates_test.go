@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"change_sn/vcu"
+)
+
+func TestScanTemplates(t *testing.T) {
+	data, err := os.ReadFile(writeTestDump(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hex, err := vcu.EncodeImage(data, 0, vcu.FormatIntelHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		vcu.ManifestName:                     {Data: []byte(`{"templates": []}`)},
+		"MEMORY_G3_1CGCC1234C1234_1.5.5.bin": {Data: data},
+		"MEMORY_G3_1CGCC1234C1234_1.5.4.hex": {Data: hex},
+		"MEMORY_G3_unversioned.bin":          {Data: data},
+	}
+
+	m, skipped, err := scanTemplates(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Templates) != 1 || m.Templates[0].Version != "1.5.5" {
+		t.Fatalf("templates %+v, want only the 1.5.5 .bin file", m.Templates)
+	}
+	if !slices.Equal(skipped, []string{"MEMORY_G3_unversioned.bin"}) {
+		t.Errorf("skipped %v, want the unversioned .bin file", skipped)
+	}
+
+	// A store built from the scan loads the template it describes.
+	store := vcu.NewTemplateStore(fsys, m)
+	dump, err := store.Load(m.Templates[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serials := dump.Serials(); len(serials) == 0 || serials[0].Value != testSerial {
+		t.Errorf("loaded serials %v, want %s", serials, testSerial)
+	}
+}
+
+func TestTemplateOutputBase(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"tmpl.bin", "tmpl.hex"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		template, want string
+	}{
+		{filepath.Join(dir, "tmpl.bin"), "tmpl.bin"},
+		{filepath.Join(dir, "tmpl.hex"), "tmpl.bin"},
+		{"1.5.5", "MEMORY_G3_1.5.5.bin"},
+	} {
+		if got := templateOutputBase(tt.template); got != tt.want {
+			t.Errorf("templateOutputBase(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"change_sn/vcu"
+)
+
+// runUndo restores the original dump a change journal was recorded from.
+func runUndo(args []string) error {
+	flags := newFlagSet("undo")
+	journalPath := flags.String("journal", "", "change journal (default: next to the patched dump)")
+	out := flags.String("out", "", "restored dump (default: <patched>.restored.<ext>, numbered if taken)")
+	overwrite := flags.Bool("overwrite", false, "replace an existing output file")
+	files, err := parseFlagsArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return usageErrorf("undo needs exactly one patched dump")
+	}
+	patchedPath := files[0]
+	if *journalPath == "" {
+		*journalPath = vcu.JournalPath(patchedPath)
+	}
+	if *out == "" {
+		ext := filepath.Ext(patchedPath)
+		if !isDumpName(patchedPath) {
+			ext = ".bin"
+		}
+		*out = nextFreeName(strings.TrimSuffix(patchedPath, filepath.Ext(patchedPath)) + ".restored" + ext)
+	}
+
+	j, err := vcu.ReadJournal(*journalPath)
+	if err != nil {
+		return err
+	}
+	patched, err := vcu.Load(patchedPath)
+	if err != nil {
+		return err
+	}
+	restored, err := j.Undo(patched)
+	if err != nil {
+		return err
+	}
+
+	if err = writeImage(*out, restored, *overwrite); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Restored %d byte range(s) from %s\n", len(j.Ranges), *journalPath)
+	fmt.Printf("✅ SHA-256 matches original %s: %s\n", j.Input.Path, j.Input.SHA256)
+	fmt.Println("✅ Original dump written to:", *out)
+	return nil
+}
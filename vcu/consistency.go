@@ -0,0 +1,200 @@
+package vcu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// mirrorDelta is the distance between a field in config A and its copy in
+// config B.
+const mirrorDelta = ConfigBOffset - ConfigAOffset
+
+// Config copies.
+const (
+	CopyA = "A"
+	CopyB = "B"
+)
+
+var (
+	ErrInconsistent  = errors.New("vcu: config copies disagree")
+	ErrAmbiguousCopy = errors.New("vcu: cannot tell which config copy is healthy")
+	ErrUnknownCopy   = errors.New("vcu: config copy must be A or B")
+)
+
+// Mismatch is a mirrored field whose two copies differ.
+type Mismatch struct {
+	Field   Field // the copy in config A
+	A       string
+	B       string
+	Repairs string // value Repair would write to both copies
+}
+
+// Consistency is the result of CheckConsistency.
+type Consistency struct {
+	Mismatches []Mismatch
+	// Healthy is the copy that looks intact, or "" when both look equally
+	// valid (or equally damaged).
+	Healthy string
+	// SpeedMajority is the value most speed bytes agree on, or -1.
+	SpeedMajority int
+}
+
+func (c Consistency) OK() bool {
+	return len(c.Mismatches) == 0
+}
+
+// mirroredFields returns the config A fields that have a copy in config B.
+// The key is stored once and is not part of the comparison.
+func (d *Dump) mirroredFields() []Field {
+	var fields []Field
+	for _, f := range d.Fields() {
+		if f.Kind == KindKey || f.Offset < ConfigAOffset || f.Offset >= ConfigBOffset {
+			continue
+		}
+		if f.Kind == KindMileage {
+			f.Name = KindMileage
+		}
+		fields = append(fields, f)
+	}
+	// A serial damaged in config A is not found by Serials, so look for
+	// serials found only in config B as well.
+	for _, sn := range d.Serials() {
+		if sn.Offset >= ConfigBOffset && sn.Offset < DescriptorOffset && !d.hasSerialAt(sn.Offset-mirrorDelta) {
+			fields = append(fields, Field{"serial", KindSerial, sn.Offset - mirrorDelta, d.layout.SerialLength})
+		}
+	}
+	return fields
+}
+
+func (d *Dump) hasSerialAt(offset int) bool {
+	for _, sn := range d.Serials() {
+		if sn.Offset == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldValid reports whether the bytes of f look like a sane value.
+func (d *Dump) fieldValid(f Field, offset int) bool {
+	b := d.data[offset : offset+f.Length]
+	switch f.Kind {
+	case KindSerial:
+		return bytes.HasPrefix(b, []byte(d.layout.SerialPrefix))
+	case KindMileage:
+		return !bytes.Equal(b, []byte{0xFF, 0xFF})
+	case KindSpeed:
+		return b[0] >= MinSpeed && b[0] <= MaxSpeed
+	}
+	return true
+}
+
+// speedMajority returns the value held by more than half of the speed
+// bytes, or -1 when there is no such majority.
+func (d *Dump) speedMajority() int {
+	counts := map[byte]int{}
+	speeds := d.Speeds()
+	for _, s := range speeds {
+		counts[s]++
+	}
+	for v, n := range counts {
+		if n*2 > len(speeds) {
+			return int(v)
+		}
+	}
+	return -1
+}
+
+// CheckConsistency compares config A with its mirror in config B field by
+// field and guesses which copy is healthy.
+func (d *Dump) CheckConsistency() Consistency {
+	c := Consistency{SpeedMajority: d.speedMajority()}
+	scoreA, scoreB := 0, 0
+	for _, f := range d.mirroredFields() {
+		if d.fieldValid(f, f.Offset) {
+			scoreA++
+		}
+		if d.fieldValid(f, f.Offset+mirrorDelta) {
+			scoreB++
+		}
+
+		a := d.data[f.Offset : f.Offset+f.Length]
+		b := d.data[f.Offset+mirrorDelta : f.Offset+mirrorDelta+f.Length]
+		if !bytes.Equal(a, b) {
+			c.Mismatches = append(c.Mismatches, Mismatch{Field: f, A: f.Format(a), B: f.Format(b)})
+		}
+	}
+
+	switch {
+	case scoreA > scoreB:
+		c.Healthy = CopyA
+	case scoreB > scoreA:
+		c.Healthy = CopyB
+	}
+	for i, m := range c.Mismatches {
+		c.Mismatches[i].Repairs = d.repairValue(m.Field, c.Healthy, c.SpeedMajority)
+	}
+	return c
+}
+
+// repairValue returns the formatted value Repair would pick, or "" when it
+// would need an explicit source copy.
+func (d *Dump) repairValue(f Field, source string, majority int) string {
+	if f.Kind == KindSpeed && majority >= 0 {
+		return f.Format([]byte{byte(majority)})
+	}
+	switch source {
+	case CopyA:
+		return f.Format(d.data[f.Offset : f.Offset+f.Length])
+	case CopyB:
+		return f.Format(d.data[f.Offset+mirrorDelta : f.Offset+mirrorDelta+f.Length])
+	}
+	return ""
+}
+
+// Repair rebuilds the mismatching fields. Speed bytes take the majority
+// value when there is one; every other field is copied from source, which
+// is CopyA, CopyB or "" to use the copy CheckConsistency found healthy.
+// It returns the number of fields repaired.
+func (d *Dump) Repair(source string) (int, error) {
+	c := d.CheckConsistency()
+	if c.OK() {
+		return 0, nil
+	}
+
+	if source == "" {
+		source = c.Healthy
+	}
+	switch source {
+	case CopyA, CopyB, "":
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCopy, source)
+	}
+
+	for _, m := range c.Mismatches {
+		if m.Field.Kind == KindSpeed && c.SpeedMajority >= 0 {
+			continue
+		}
+		if source == "" {
+			return 0, ErrAmbiguousCopy
+		}
+	}
+
+	repaired := 0
+	for _, m := range c.Mismatches {
+		f := m.Field
+		a := d.data[f.Offset : f.Offset+f.Length]
+		b := d.data[f.Offset+mirrorDelta : f.Offset+mirrorDelta+f.Length]
+		switch {
+		case f.Kind == KindSpeed && c.SpeedMajority >= 0:
+			a[0], b[0] = byte(c.SpeedMajority), byte(c.SpeedMajority)
+		case source == CopyA:
+			copy(b, a)
+		default:
+			copy(a, b)
+		}
+		repaired++
+	}
+	return repaired, nil
+}
@@ -0,0 +1,178 @@
+package vcu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+const testSerial = "1CGCC1234C1234"
+
+// mirroredDump returns a full dump whose config copies agree: the serial,
+// a mileage of 16 and a speed limit of 25 in both.
+func mirroredDump(t *testing.T) []byte {
+	t.Helper()
+	data := testDump(t, nil).Bytes()
+	for _, o := range []int{ConfigAOffset, ConfigBOffset} {
+		copy(data[o:], testSerial)
+	}
+	binary.LittleEndian.PutUint16(data[DefaultLayout.MileageA:], 16)
+	binary.LittleEndian.PutUint16(data[DefaultLayout.MileageB:], 16)
+	for _, o := range DefaultLayout.Speeds {
+		data[o] = 25
+	}
+	return data
+}
+
+func TestRepair(t *testing.T) {
+	setMileage := func(b []byte, a, bm uint16) {
+		binary.LittleEndian.PutUint16(b[DefaultLayout.MileageA:], a)
+		binary.LittleEndian.PutUint16(b[DefaultLayout.MileageB:], bm)
+	}
+	for _, tt := range []struct {
+		name        string
+		damage      func(b []byte)
+		source      string
+		healthy     string
+		mismatches  int
+		repaired    int
+		wantErr     error
+		wantMileage uint16
+		wantSpeeds  []byte
+	}{
+		{
+			name:        "consistent",
+			damage:      func([]byte) {},
+			wantMileage: 16,
+		},
+		{
+			name:        "mileage B erased",
+			damage:      func(b []byte) { setMileage(b, 16, 0xFFFF) },
+			healthy:     CopyA,
+			mismatches:  1,
+			repaired:    1,
+			wantMileage: 16,
+		},
+		{
+			name:        "serial A without prefix",
+			damage:      func(b []byte) { b[ConfigAOffset] = 'X' },
+			healthy:     CopyB,
+			mismatches:  1,
+			repaired:    1,
+			wantMileage: 16,
+		},
+		{
+			name:       "copies disagree, both plausible",
+			damage:     func(b []byte) { setMileage(b, 16, 20) },
+			mismatches: 1,
+			wantErr:    ErrAmbiguousCopy,
+		},
+		{
+			name:        "tie broken by an explicit source",
+			damage:      func(b []byte) { setMileage(b, 16, 20) },
+			source:      CopyB,
+			mismatches:  1,
+			repaired:    1,
+			wantMileage: 20,
+		},
+		{
+			name:        "speed outvoted by the majority",
+			damage:      func(b []byte) { b[DefaultLayout.Speeds[3]] = 30 },
+			mismatches:  1,
+			repaired:    1,
+			wantMileage: 16,
+			wantSpeeds:  []byte{25, 25, 25, 25},
+		},
+		{
+			name:       "speeds split evenly",
+			damage:     func(b []byte) { b[DefaultLayout.Speeds[2]], b[DefaultLayout.Speeds[3]] = 30, 30 },
+			mismatches: 2,
+			wantErr:    ErrAmbiguousCopy,
+		},
+		{
+			name:        "speeds split evenly, source A",
+			damage:      func(b []byte) { b[DefaultLayout.Speeds[2]], b[DefaultLayout.Speeds[3]] = 30, 30 },
+			source:      CopyA,
+			mismatches:  2,
+			repaired:    2,
+			wantMileage: 16,
+			wantSpeeds:  []byte{25, 25, 25, 25},
+		},
+		{
+			name:       "unknown source",
+			damage:     func(b []byte) { setMileage(b, 16, 20) },
+			source:     "C",
+			mismatches: 1,
+			wantErr:    ErrUnknownCopy,
+		},
+	} {
+		data := mirroredDump(t)
+		tt.damage(data)
+		d, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := d.CheckConsistency()
+		if len(c.Mismatches) != tt.mismatches || c.Healthy != tt.healthy {
+			t.Errorf("%s: %d mismatches, healthy %q; want %d, %q", tt.name, len(c.Mismatches), c.Healthy, tt.mismatches, tt.healthy)
+		}
+
+		n, err := d.Repair(tt.source)
+		if n != tt.repaired || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Repair = %d, %v; want %d, %v", tt.name, n, err, tt.repaired, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if !bytes.Equal(d.Bytes(), data) {
+				t.Errorf("%s: failed repair changed the dump", tt.name)
+			}
+			continue
+		}
+
+		if c := d.CheckConsistency(); !c.OK() {
+			t.Errorf("%s: still inconsistent after repair: %+v", tt.name, c.Mismatches)
+		}
+		if a, b := d.Mileage(); a != tt.wantMileage || b != tt.wantMileage {
+			t.Errorf("%s: mileage %d/%d, want %d", tt.name, a, b, tt.wantMileage)
+		}
+		if sn := d.Serials(); len(sn) != 2 || sn[0].Value != testSerial || sn[1].Value != testSerial {
+			t.Errorf("%s: serials %v, want %s twice", tt.name, sn, testSerial)
+		}
+		if tt.wantSpeeds != nil && !bytes.Equal(d.Speeds(), tt.wantSpeeds) {
+			t.Errorf("%s: speeds %v, want %v", tt.name, d.Speeds(), tt.wantSpeeds)
+		}
+	}
+}
+
+func TestMirroredFields(t *testing.T) {
+	data := mirroredDump(t)
+	d, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range d.mirroredFields() {
+		if f.Offset < ConfigAOffset || f.Offset >= ConfigBOffset {
+			t.Errorf("field %s at 0x%05X is outside config A", f.Name, f.Offset)
+		}
+		if f.Kind == KindKey {
+			t.Error("the key is stored once and must not be compared")
+		}
+		names = append(names, f.Name)
+	}
+	if len(names) != 4 {
+		t.Errorf("mirrored fields %v, want the serial, the mileage and two speeds", names)
+	}
+
+	// A serial damaged in config A is still compared through its copy in B.
+	data[ConfigAOffset] = 'X'
+	if d, err = Parse(data); err != nil {
+		t.Fatal(err)
+	}
+	fields := d.mirroredFields()
+	if len(fields) != 4 || fields[len(fields)-1].Offset != ConfigAOffset || fields[len(fields)-1].Kind != KindSerial {
+		t.Errorf("with serial A damaged, mirrored fields %+v, want the serial at 0x%05X", fields, ConfigAOffset)
+	}
+}
@@ -0,0 +1,176 @@
+package vcu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The bootloader reads a 12-byte descriptor at 0x0801F800 on every boot.
+// The layout below is taken from the stock bootloader (bootHeader), by
+// address in flash:
+//
+//	0x0 uint32 magic, 0x0000505A
+//	0x4 uint32 pending, non-zero to install the staged image
+//	0x8 uint32 length of the staged image in bytes
+//
+// The functions that use it:
+//   - 0x080005B0 copies 12 bytes from 0x0801F800 (literal at 0x080005DC)
+//     to RAM at 0x2000003C and compares the first word with the default
+//     descriptor at 0x08000DA0, 5A 50 00 00 followed by eight zero bytes.
+//     On a mismatch it copies the default to RAM and saves it with
+//     0x0800060C, which erases the 1 KiB page at 0x0801F800 and programs
+//     the 12 bytes back.
+//   - 0x080004B8 loads the word at offset 4 ("ldr r0, [r0, #4]; cbz") and
+//     starts the application at 0x08001000 when it is zero. Otherwise it
+//     installs the update with 0x0800050C, stores 0 at offset 4, saves the
+//     descriptor and starts the application.
+//   - 0x0800050C loads the word at offset 8 and fails when it is zero. It
+//     checks the stack pointer of the staged image at 0x08010000 ("ands"
+//     with 0x2FFE0000, "cmp" with 0x20000000), erases length bytes at
+//     0x08001000 in whole pages and copies the staging area over them 256
+//     bytes at a time through RAM at 0x20000048, until length is reached.
+//
+// Only those three words are read, so the descriptor has no checksum: a
+// staged image that is damaged or longer than length is installed as it
+// is. Other bootloaders may use another layout, so the descriptor is only
+// written in dumps that start with this one.
+const (
+	DescriptorSize  = 12
+	DescriptorMagic = 0x505A
+
+	// copyBlock is the unit the bootloader copies in; length is rounded
+	// up to it.
+	copyBlock = 0x100
+)
+
+var (
+	ErrStaging          = errors.New("vcu: staged image cannot be installed")
+	ErrDescriptorFormat = errors.New("vcu: descriptor layout not verified for this bootloader")
+)
+
+// Descriptor is the update descriptor.
+type Descriptor struct {
+	Magic   uint32
+	Pending uint32
+	Length  uint32
+}
+
+// Valid reports whether the bootloader accepts the descriptor instead of
+// resetting it.
+func (d Descriptor) Valid() bool {
+	return d.Magic == DescriptorMagic
+}
+
+// Armed reports whether the bootloader installs the staged image on the
+// next boot.
+func (d Descriptor) Armed() bool {
+	return d.Valid() && d.Pending != 0
+}
+
+// DecodeDescriptor decodes the first DescriptorSize bytes of b.
+func DecodeDescriptor(b []byte) Descriptor {
+	return Descriptor{
+		Magic:   binary.LittleEndian.Uint32(b),
+		Pending: binary.LittleEndian.Uint32(b[4:]),
+		Length:  binary.LittleEndian.Uint32(b[8:]),
+	}
+}
+
+// Bytes encodes the descriptor as it is stored in flash.
+func (d Descriptor) Bytes() []byte {
+	b := make([]byte, 0, DescriptorSize)
+	b = binary.LittleEndian.AppendUint32(b, d.Magic)
+	b = binary.LittleEndian.AppendUint32(b, d.Pending)
+	return binary.LittleEndian.AppendUint32(b, d.Length)
+}
+
+// Descriptor decodes the update descriptor. It is in the config sector,
+// so config-only dumps have it too.
+func (d *Dump) Descriptor() Descriptor {
+	return DecodeDescriptor(d.data[DescriptorOffset:])
+}
+
+// CheckDescriptorFormat returns ErrDescriptorFormat unless the dump starts
+// with the bootloader the descriptor layout was read from.
+func (d *Dump) CheckDescriptorFormat() error {
+	switch {
+	case d.Partial():
+		return fmt.Errorf("%w: a config-only dump has no bootloader to check", ErrDescriptorFormat)
+	case !d.HeaderValid():
+		return fmt.Errorf("%w: the dump does not start with the stock bootloader", ErrDescriptorFormat)
+	}
+	return nil
+}
+
+// DescriptorErased reports whether the descriptor bytes are all 0xFF, as
+// on a VCU that never booted the stock bootloader.
+func (d *Dump) DescriptorErased() bool {
+	return bytes.Count(d.data[DescriptorOffset:DescriptorOffset+DescriptorSize], []byte{0xFF}) == DescriptorSize
+}
+
+// SetDescriptor writes desc without any checks.
+func (d *Dump) SetDescriptor(desc Descriptor) {
+	copy(d.data[DescriptorOffset:], desc.Bytes())
+}
+
+// CheckStaging reports why the bootloader would fail to install, or would
+// install a truncated copy of, a staged image of the given length. The
+// vector table check is stricter than the bootloader's, which only looks
+// at the stack pointer.
+func (d *Dump) CheckStaging(length int) error {
+	if d.Partial() {
+		return fmt.Errorf("%w: config-only dump has no staging area", ErrStaging)
+	}
+	switch {
+	case length <= 0:
+		return fmt.Errorf("%w: length is 0, the bootloader refuses to copy", ErrStaging)
+	case length > AppSize:
+		return fmt.Errorf("%w: length %d is larger than the application region (%d)", ErrStaging, length, AppSize)
+	}
+
+	staging := d.data[StagingOffset:StagingEnd]
+	if err := checkVectors(staging); err != nil {
+		return fmt.Errorf("%w: %v", ErrStaging, err)
+	}
+	copied := (length + copyBlock - 1) / copyBlock * copyBlock
+	if used := len(bytes.TrimRight(staging, "\xff")); used > copied {
+		return fmt.Errorf("%w: %d bytes are staged but only %d would be copied", ErrStaging, used, copied)
+	}
+	return nil
+}
+
+// StagedLength returns the length of the staged image: the staging area
+// without its trailing erased bytes, in whole flash words.
+func (d *Dump) StagedLength() int {
+	n := len(bytes.TrimRight(d.data[StagingOffset:StagingEnd], "\xff"))
+	return (n + 3) / 4 * 4
+}
+
+// ArmUpdate sets the descriptor so the bootloader installs the staged
+// image of the given length on the next boot. A length of 0 uses
+// StagedLength. The bootloader and the staging area are checked first.
+func (d *Dump) ArmUpdate(length int) error {
+	if err := d.CheckDescriptorFormat(); err != nil {
+		return err
+	}
+	if length == 0 {
+		length = d.StagedLength()
+	}
+	if err := d.CheckStaging(length); err != nil {
+		return err
+	}
+	d.SetDescriptor(Descriptor{Magic: DescriptorMagic, Pending: 1, Length: uint32(length)})
+	return nil
+}
+
+// ClearUpdate writes the descriptor the bootloader uses when no update is
+// pending. The bootloader is checked first.
+func (d *Dump) ClearUpdate() error {
+	if err := d.CheckDescriptorFormat(); err != nil {
+		return err
+	}
+	d.SetDescriptor(Descriptor{Magic: DescriptorMagic})
+	return nil
+}
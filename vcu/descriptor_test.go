@@ -0,0 +1,159 @@
+package vcu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// TestDescriptorMatchesBootloader checks the constants against the stock
+// bootloader the descriptor layout was read from.
+func TestDescriptorMatchesBootloader(t *testing.T) {
+	word := func(offset int) uint32 {
+		return binary.LittleEndian.Uint32(bootHeader[offset:])
+	}
+	for _, tt := range []struct {
+		name   string
+		offset int // of a literal in the bootloader
+		want   uint32
+	}{
+		{"descriptor address, load", 0x5DC, FlashBase + DescriptorOffset},
+		{"descriptor address, save", 0x630, FlashBase + DescriptorOffset},
+		{"staging address", 0x584, FlashBase + StagingOffset},
+		{"application address, install", 0x58C, FlashBase + AppOffset},
+		{"application address, start", 0x508, FlashBase + AppOffset},
+		{"default descriptor address", 0x5E0, FlashBase + 0xDA0},
+	} {
+		if got := word(tt.offset); got != tt.want {
+			t.Errorf("%s: literal at 0x%03X is 0x%08X, want 0x%08X", tt.name, tt.offset, got, tt.want)
+		}
+	}
+	// mov.w r2, #256 before each call in the copy loop.
+	for _, offset := range []int{0x548, 0x554} {
+		if got := bootHeader[offset : offset+4]; !bytes.Equal(got, []byte{0x4F, 0xF4, 0x80, 0x72}) {
+			t.Errorf("copy size at 0x%03X is % X, want mov.w r2, #%d", offset, got, copyBlock)
+		}
+	}
+}
+
+func TestDecodeDescriptor(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		raw          []byte
+		want         Descriptor
+		valid, armed bool
+	}{
+		// The default the bootloader writes, as stored in it at 0x0DA0.
+		{"bootloader default", bootHeader[0xDA0 : 0xDA0+DescriptorSize], Descriptor{Magic: DescriptorMagic}, true, false},
+		{"armed", []byte{0x5A, 0x50, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x4E, 0x00, 0x00},
+			Descriptor{Magic: DescriptorMagic, Pending: 1, Length: 0x4E00}, true, true},
+		{"pending is any non-zero word", []byte{0x5A, 0x50, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00},
+			Descriptor{Magic: DescriptorMagic, Pending: 0x10000, Length: 0x100}, true, true},
+		{"magic is a whole word", []byte{0x5A, 0x50, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0x00, 0x00, 0x4E, 0x00, 0x00},
+			Descriptor{Magic: 0xFFFF505A, Pending: 1, Length: 0x4E00}, false, false},
+		{"erased", bytes.Repeat([]byte{0xFF}, DescriptorSize),
+			Descriptor{Magic: 0xFFFFFFFF, Pending: 0xFFFFFFFF, Length: 0xFFFFFFFF}, false, false},
+	} {
+		got := DecodeDescriptor(tt.raw)
+		if got != tt.want || got.Valid() != tt.valid || got.Armed() != tt.armed {
+			t.Errorf("%s: %+v valid %v armed %v, want %+v valid %v armed %v",
+				tt.name, got, got.Valid(), got.Armed(), tt.want, tt.valid, tt.armed)
+		}
+		if !bytes.Equal(got.Bytes(), tt.raw) {
+			t.Errorf("%s: Bytes = % X, want % X", tt.name, got.Bytes(), tt.raw)
+		}
+	}
+}
+
+// testDump returns an erased full dump that starts with the stock
+// bootloader and has app staged.
+func testDump(t *testing.T, app []byte) *Dump {
+	t.Helper()
+	data := bytes.Repeat([]byte{0xFF}, DumpSize)
+	copy(data, bootHeader)
+	d, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app != nil {
+		if err = d.SetStaging(app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+// testApp returns an application of n bytes with a valid vector table.
+func testApp(n int) []byte {
+	app := make([]byte, n)
+	binary.LittleEndian.PutUint32(app, 0x20002000)
+	binary.LittleEndian.PutUint32(app[4:], FlashBase+AppOffset+0x101)
+	return app
+}
+
+func TestArmAndClearUpdate(t *testing.T) {
+	d := testDump(t, testApp(0x2F0))
+	if !d.DescriptorErased() || d.Descriptor().Valid() {
+		t.Fatalf("new dump has descriptor %+v", d.Descriptor())
+	}
+	if err := d.ArmUpdate(0); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x5A, 0x50, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0xF0, 0x02, 0x00, 0x00}
+	if got := d.Bytes()[DescriptorOffset : DescriptorOffset+DescriptorSize]; !bytes.Equal(got, want) {
+		t.Errorf("armed descriptor % X, want % X", got, want)
+	}
+	if err := d.ClearUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Descriptor(); got != (Descriptor{Magic: DescriptorMagic}) {
+		t.Errorf("cleared descriptor %+v", got)
+	}
+}
+
+func TestDescriptorNotWrittenForOtherBootloaders(t *testing.T) {
+	other := testDump(t, testApp(0x100))
+	other.Bytes()[0x5B0] ^= 0xFF // another bootloader build
+
+	config, err := Parse(bytes.Repeat([]byte{0xFF}, ConfigSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, d := range map[string]*Dump{"other bootloader": other, "config-only": config} {
+		before := d.Descriptor()
+		if err := d.ArmUpdate(0x100); !errors.Is(err, ErrDescriptorFormat) {
+			t.Errorf("%s: ArmUpdate: %v, want ErrDescriptorFormat", name, err)
+		}
+		if err := d.ClearUpdate(); !errors.Is(err, ErrDescriptorFormat) {
+			t.Errorf("%s: ClearUpdate: %v, want ErrDescriptorFormat", name, err)
+		}
+		if d.Descriptor() != before {
+			t.Errorf("%s: descriptor changed to %+v", name, d.Descriptor())
+		}
+	}
+}
+
+func TestCheckStaging(t *testing.T) {
+	badSP := testApp(0x100)
+	binary.LittleEndian.PutUint32(badSP, 0x08000000)
+	for _, tt := range []struct {
+		name   string
+		app    []byte
+		length int
+		ok     bool
+	}{
+		{"whole image", testApp(0x300), 0x300, true},
+		{"rounded up to the copy block", testApp(0x300), 0x201, true},
+		{"truncated", testApp(0x300), 0x200, false},
+		{"zero length", testApp(0x300), 0, false},
+		{"longer than the application region", testApp(0x300), AppSize + 4, false},
+		{"stack pointer outside RAM", badSP, 0x100, false},
+		{"nothing staged", nil, 0x100, false},
+	} {
+		err := testDump(t, tt.app).CheckStaging(tt.length)
+		if tt.ok != (err == nil) || err != nil && !errors.Is(err, ErrStaging) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
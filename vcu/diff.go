@@ -0,0 +1,93 @@
+package vcu
+
+import (
+	"bytes"
+	"slices"
+)
+
+// FieldChange is a known field whose value differs between two dumps.
+type FieldChange struct {
+	Field Field
+	Old   string
+	New   string
+}
+
+// ByteRun is a run of changed bytes outside any known field.
+type ByteRun struct {
+	Offset int
+	Old    []byte
+	New    []byte
+}
+
+// RegionDiff holds the changes inside one region.
+type RegionDiff struct {
+	Region  Region
+	Changed int
+	Fields  []FieldChange
+	Runs    []ByteRun
+}
+
+// Diff compares two dumps and groups the differences by region. Regions
+// without changes are omitted. Fields are located with the layouts of both
+// dumps, so a serial found in only one of them is still labelled. When
+// either dump is config-only, only the config sector is compared.
+func Diff(a, b *Dump) []RegionDiff {
+	fields := a.Fields()
+	for _, f := range b.Fields() {
+		if !slices.ContainsFunc(fields, func(g Field) bool { return g.Offset == f.Offset && g.Kind == f.Kind }) {
+			fields = append(fields, f)
+		}
+	}
+	slices.SortStableFunc(fields, func(x, y Field) int { return x.Offset - y.Offset })
+
+	covered := make([]bool, DumpSize)
+	for _, f := range fields {
+		for i := f.Offset; i < f.Offset+f.Length; i++ {
+			covered[i] = true
+		}
+	}
+
+	var diffs []RegionDiff
+	for _, r := range Regions {
+		if r.Start < max(a.start, b.start) {
+			continue
+		}
+		rd := RegionDiff{Region: r}
+		for i := r.Start; i < r.End; i++ {
+			if a.data[i] != b.data[i] {
+				rd.Changed++
+			}
+		}
+		if rd.Changed == 0 {
+			continue
+		}
+
+		for _, f := range fields {
+			if !r.Contains(f.Offset) {
+				continue
+			}
+			old, cur := a.fieldBytes(f), b.fieldBytes(f)
+			if !bytes.Equal(old, cur) {
+				rd.Fields = append(rd.Fields, FieldChange{Field: f, Old: f.Format(old), New: f.Format(cur)})
+			}
+		}
+
+		for i := r.Start; i < r.End; i++ {
+			if covered[i] || a.data[i] == b.data[i] {
+				continue
+			}
+			j := i
+			for j < r.End && !covered[j] && a.data[j] != b.data[j] {
+				j++
+			}
+			rd.Runs = append(rd.Runs, ByteRun{
+				Offset: i,
+				Old:    bytes.Clone(a.data[i:j]),
+				New:    bytes.Clone(b.data[i:j]),
+			})
+			i = j - 1
+		}
+		diffs = append(diffs, rd)
+	}
+	return diffs
+}
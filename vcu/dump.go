@@ -0,0 +1,274 @@
+// Package vcu reads and patches Ninebot MAX G3 VCU flash dumps.
+//
+// A Dump wraps the raw 128 KiB image and exposes the fields the tool knows
+// how to edit: serial numbers, the two mileage copies, the speed limit
+// bytes and the secret key. Their offsets come from a Layout profile.
+// Setters validate their input and return errors instead of exiting, so the
+// package can be used outside the CLI.
+package vcu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	DumpSize = 0x20000
+	// ConfigSize is the size of a config-only dump: the last 4 KiB of
+	// flash, from config A to the end.
+	ConfigSize = DumpSize - ConfigAOffset
+
+	MaxMileage = 0xFFFF
+	MinSpeed   = 1
+	MaxSpeed   = 125
+)
+
+// Dump is a flash image together with the layout profile used to locate
+// its fields. A config-only dump is kept as a full image with everything
+// before ConfigAOffset erased, so field offsets are the same for both.
+type Dump struct {
+	data   []byte
+	layout *Layout
+	start  int           // offset of the first byte that was read
+	srec   SRecordStyle  // style of the S-record file read, if any
+	ihex   IntelHexStyle // style of the Intel HEX file read, if any
+}
+
+// Serial is a serial number occurrence inside a dump.
+type Serial struct {
+	Offset int
+	Value  string
+}
+
+// Parse wraps data as a dump. The slice is copied. Data of ConfigSize bytes
+// is taken as a config-only dump. The layout is chosen from the detected
+// firmware and falls back to DefaultLayout.
+func Parse(data []byte) (*Dump, error) {
+	d := &Dump{layout: DefaultLayout}
+	switch len(data) {
+	case DumpSize:
+		d.data = bytes.Clone(data)
+	case ConfigSize:
+		d.data = bytes.Repeat([]byte{0xFF}, DumpSize)
+		d.start = ConfigAOffset
+		copy(d.data[d.start:], data)
+	default:
+		return nil, fmt.Errorf("%w: got %d bytes, want %d or %d", ErrSize, len(data), DumpSize, ConfigSize)
+	}
+	if l, ok := LayoutFor(d.DetectFirmware().Version, d.BootSHA256()); ok {
+		d.layout = l
+	}
+	return d, nil
+}
+
+// Read parses a dump from r.
+func Read(r io.Reader) (*Dump, error) {
+	data, err := io.ReadAll(io.LimitReader(r, DumpSize+1))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Load parses the dump stored in the file at path, which may be a raw
+// image, Intel HEX or S-record file.
+// S-record files are written back in the record type they were read in.
+func Load(path string) (*Dump, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := DetectFormat(path, raw)
+	data, err := DecodeImage(raw, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	d, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatSRecord:
+		d.srec = DetectSRecordStyle(raw)
+	case FormatIntelHex:
+		d.ihex = DetectIntelHexStyle(raw)
+	}
+	return d, nil
+}
+
+// Bytes returns the underlying image as it was read: the full flash, or
+// only the config sector of a config-only dump. Changes to it are visible
+// to the dump.
+func (d *Dump) Bytes() []byte {
+	return d.data[d.start:]
+}
+
+// Start returns the offset of the first byte of Bytes.
+func (d *Dump) Start() int {
+	return d.start
+}
+
+// Partial reports whether the dump holds only the config sector.
+func (d *Dump) Partial() bool {
+	return d.start > 0
+}
+
+// Clone returns an independent copy of the dump.
+func (d *Dump) Clone() *Dump {
+	return &Dump{data: bytes.Clone(d.data), layout: d.layout, start: d.start, srec: d.srec, ihex: d.ihex}
+}
+
+// Encode returns the dump in the given file format.
+func (d *Dump) Encode(format ImageFormat) ([]byte, error) {
+	switch {
+	case format == FormatSRecord && d.srec.Type != 0:
+		return EncodeSRecord(d.Bytes(), d.start, d.srec)
+	case format == FormatIntelHex && d.ihex.Extended != 0:
+		return EncodeIntelHex(d.Bytes(), d.start, d.ihex)
+	}
+	return EncodeImage(d.Bytes(), d.start, format)
+}
+
+// Layout returns the profile used to locate fields.
+func (d *Dump) Layout() *Layout {
+	return d.layout
+}
+
+// SetLayout overrides the profile chosen by Parse.
+func (d *Dump) SetLayout(l *Layout) {
+	d.layout = l
+}
+
+// Validate checks the bootloader header signature. Config-only dumps have
+// no bootloader and always pass.
+func (d *Dump) Validate() error {
+	if !d.Partial() && !d.HeaderValid() {
+		return ErrHeader
+	}
+	return nil
+}
+
+// Serials returns every serial number in the dump, skipping the
+// placeholder serial used by the factory image.
+func (d *Dump) Serials() []Serial {
+	l := d.layout
+	var serials []Serial
+	for i := 0; i <= len(d.data)-l.SerialLength; i++ {
+		if !bytes.HasPrefix(d.data[i:], []byte(l.SerialPrefix)) {
+			continue
+		}
+		sn := d.data[i : i+l.SerialLength]
+		if string(sn) != l.SkipSerial {
+			serials = append(serials, Serial{Offset: i, Value: string(sn)})
+		}
+		i += l.SerialLength - 1
+	}
+	return serials
+}
+
+// SetSerial replaces every serial number with serial and returns how many
+// were replaced. The serial is validated with ParseSerial.
+func (d *Dump) SetSerial(serial string) (int, error) {
+	sn, err := ParseSerial(serial, d.layout)
+	if err != nil {
+		return 0, err
+	}
+
+	serials := d.Serials()
+	if len(serials) == 0 {
+		return 0, ErrNoSerials
+	}
+	for _, s := range serials {
+		copy(d.data[s.Offset:s.Offset+len(sn.Raw)], sn.Raw)
+	}
+	return len(serials), nil
+}
+
+// Mileage returns both mileage copies in units of 0.1 km.
+func (d *Dump) Mileage() (a, b uint16) {
+	a, _ = readUint16At(d.data, int(d.layout.MileageA))
+	b, _ = readUint16At(d.data, int(d.layout.MileageB))
+	return a, b
+}
+
+// SetMileage writes v to both mileage copies.
+func (d *Dump) SetMileage(v int) error {
+	if v < 0 || v > MaxMileage {
+		return &RangeError{Field: "mileage", Value: v, Min: 0, Max: MaxMileage}
+	}
+	if err := writeUint16At(d.data, int(d.layout.MileageA), uint16(v)); err != nil {
+		return err
+	}
+	return writeUint16At(d.data, int(d.layout.MileageB), uint16(v))
+}
+
+// Speeds returns the speed limit bytes in layout order.
+func (d *Dump) Speeds() []byte {
+	speeds := make([]byte, len(d.layout.Speeds))
+	for i, offset := range d.layout.Speeds {
+		speeds[i] = d.data[offset]
+	}
+	return speeds
+}
+
+// SetSpeed writes v to all speed limit offsets.
+func (d *Dump) SetSpeed(v int) error {
+	if v < MinSpeed || v > MaxSpeed {
+		return &RangeError{Field: "speed", Value: v, Min: MinSpeed, Max: MaxSpeed}
+	}
+	for _, offset := range d.layout.Speeds {
+		d.data[offset] = byte(v)
+	}
+	return nil
+}
+
+// Key returns a copy of the secret key.
+func (d *Dump) Key() []byte {
+	l := d.layout
+	return bytes.Clone(d.data[l.KeyOffset : int(l.KeyOffset)+l.KeyLength])
+}
+
+// SetKey overwrites the secret key.
+func (d *Dump) SetKey(key []byte) error {
+	l := d.layout
+	if len(key) != l.KeyLength {
+		return fmt.Errorf("%w: got %d bytes, want %d", ErrKeyLength, len(key), l.KeyLength)
+	}
+	copy(d.data[l.KeyOffset:int(l.KeyOffset)+l.KeyLength], key)
+	return nil
+}
+
+// CopyKeyFrom transfers the secret key of src into d.
+func (d *Dump) CopyKeyFrom(src *Dump) error {
+	return d.SetKey(src.Key())
+}
+
+// MergeInto returns a full image made of template with its config sector
+// replaced by the one in d. The layout of template is kept, since a
+// config-only dump carries no firmware to detect one from.
+func (d *Dump) MergeInto(template *Dump) (*Dump, error) {
+	if template.Partial() {
+		return nil, fmt.Errorf("%w: template is a config-only dump", ErrSize)
+	}
+	merged := template.Clone()
+	copy(merged.data[ConfigAOffset:], d.data[ConfigAOffset:])
+	return merged, nil
+}
+
+func readUint16At(buf []byte, offset int) (uint16, error) {
+	if offset+2 > len(buf) {
+		return 0, ErrOffset
+	}
+	return binary.LittleEndian.Uint16(buf[offset : offset+2]), nil
+}
+
+func writeUint16At(buf []byte, offset int, value uint16) error {
+	if offset+2 > len(buf) {
+		return ErrOffset
+	}
+	binary.LittleEndian.PutUint16(buf[offset:], value)
+	return nil
+}
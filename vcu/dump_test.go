@@ -0,0 +1,95 @@
+package vcu
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSetters(t *testing.T) {
+	key := []byte("0123456789AB")
+	for _, partial := range []bool{false, true} {
+		data := mirroredDump(t)
+		if partial {
+			data = data[ConfigAOffset:]
+		}
+		d, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n, err := d.SetSerial("1cgcc9999c9999"); n != 2 || err != nil {
+			t.Fatalf("partial %v: SetSerial = %d, %v; want 2 serials", partial, n, err)
+		}
+		if err = d.SetMileage(MaxMileage); err != nil {
+			t.Fatal(err)
+		}
+		if err = d.SetSpeed(MaxSpeed); err != nil {
+			t.Fatal(err)
+		}
+		if err = d.SetKey(key); err != nil {
+			t.Fatal(err)
+		}
+
+		saved, err := Parse(d.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Partial() != partial {
+			t.Errorf("partial %v: re-parsed as partial %v", partial, saved.Partial())
+		}
+		if sn := saved.Serials(); len(sn) != 2 || sn[0].Value != "1CGCC9999C9999" || sn[1].Value != "1CGCC9999C9999" {
+			t.Errorf("partial %v: serials %v", partial, sn)
+		}
+		if a, b := saved.Mileage(); a != MaxMileage || b != MaxMileage {
+			t.Errorf("partial %v: mileage %d/%d, want %d", partial, a, b, MaxMileage)
+		}
+		if speeds := saved.Speeds(); !bytes.Equal(speeds, bytes.Repeat([]byte{MaxSpeed}, len(DefaultLayout.Speeds))) {
+			t.Errorf("partial %v: speeds %v, want %d", partial, speeds, MaxSpeed)
+		}
+		if got := saved.Key(); !bytes.Equal(got, key) {
+			t.Errorf("partial %v: key %q, want %q", partial, got, key)
+		}
+		if c := saved.CheckConsistency(); !c.OK() {
+			t.Errorf("partial %v: setters left the copies inconsistent: %+v", partial, c.Mismatches)
+		}
+	}
+}
+
+func TestSettersRejectBadValues(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		set  func(d *Dump) error
+		want error
+	}{
+		{"negative mileage", func(d *Dump) error { return d.SetMileage(-1) }, ErrOutOfRange},
+		{"mileage too large", func(d *Dump) error { return d.SetMileage(MaxMileage + 1) }, ErrOutOfRange},
+		{"speed zero", func(d *Dump) error { return d.SetSpeed(MinSpeed - 1) }, ErrOutOfRange},
+		{"speed too large", func(d *Dump) error { return d.SetSpeed(MaxSpeed + 1) }, ErrOutOfRange},
+		{"short key", func(d *Dump) error { return d.SetKey([]byte("short")) }, ErrKeyLength},
+		{"bad serial", func(d *Dump) error { _, err := d.SetSerial("1CGCC9999"); return err }, ErrSerialFormat},
+		{"no serial to replace", func(d *Dump) error {
+			copy(d.data[ConfigAOffset:], "XX")
+			copy(d.data[ConfigBOffset:], "XX")
+			_, err := d.SetSerial(testSerial)
+			return err
+		}, ErrNoSerials},
+	} {
+		d, err := Parse(mirroredDump(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := bytes.Clone(d.Bytes())
+
+		if err = tt.set(d); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+		var rangeErr *RangeError
+		if errors.Is(tt.want, ErrOutOfRange) && !errors.As(err, &rangeErr) {
+			t.Errorf("%s: %T is not a *RangeError", tt.name, err)
+		}
+		if tt.want != ErrNoSerials && !bytes.Equal(d.Bytes(), before) {
+			t.Errorf("%s: rejected value changed the dump", tt.name)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package vcu
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrSize         = errors.New("vcu: dump has unexpected size")
+	ErrHeader       = errors.New("vcu: invalid bootloader header signature")
+	ErrSerialFormat = errors.New("vcu: invalid serial number format")
+	ErrNoSerials    = errors.New("vcu: no serial numbers found")
+	ErrOutOfRange   = errors.New("vcu: value out of range")
+	ErrKeyLength    = errors.New("vcu: invalid secret key length")
+	ErrOffset       = errors.New("vcu: offset out of bounds")
+)
+
+// RangeError is returned by setters when a numeric value is outside the
+// range the firmware accepts. It matches ErrOutOfRange with errors.Is.
+type RangeError struct {
+	Field    string
+	Value    int
+	Min, Max int
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("vcu: invalid %s value %d (must be %d–%d)", e.Field, e.Value, e.Min, e.Max)
+}
+
+func (e *RangeError) Is(target error) bool {
+	return target == ErrOutOfRange
+}
@@ -0,0 +1,61 @@
+package vcu
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Field kinds, which decide how a field's bytes are shown.
+const (
+	KindSerial  = "serial"
+	KindMileage = "mileage"
+	KindSpeed   = "speed"
+	KindKey     = "key"
+)
+
+// Field is a known value at a fixed place in the dump.
+type Field struct {
+	Name   string
+	Kind   string
+	Offset int
+	Length int
+}
+
+// Fields lists the fields of the dump according to its layout. Serial
+// fields come from where serials were actually found.
+func (d *Dump) Fields() []Field {
+	l := d.layout
+	var fields []Field
+	for i, sn := range d.Serials() {
+		fields = append(fields, Field{fmt.Sprintf("serial %d", i+1), KindSerial, sn.Offset, l.SerialLength})
+	}
+	fields = append(fields,
+		Field{"mileage A", KindMileage, int(l.MileageA), 2},
+		Field{"mileage B", KindMileage, int(l.MileageB), 2},
+	)
+	for i, o := range l.Speeds {
+		fields = append(fields, Field{fmt.Sprintf("speed %d", i+1), KindSpeed, int(o), 1})
+	}
+	return append(fields, Field{"secret key", KindKey, int(l.KeyOffset), l.KeyLength})
+}
+
+// Format decodes b, the bytes of the field, for display.
+func (f Field) Format(b []byte) string {
+	switch {
+	case f.Kind == KindSerial:
+		return string(b)
+	case f.Kind == KindMileage && len(b) == 2:
+		v := binary.LittleEndian.Uint16(b)
+		return fmt.Sprintf("%d (%.1f km)", v, float64(v)/10.0)
+	case f.Kind == KindSpeed && len(b) == 1:
+		return fmt.Sprintf("%d", b[0])
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// fieldBytes returns the bytes of f in d.
+func (d *Dump) fieldBytes(f Field) []byte {
+	return d.data[f.Offset : f.Offset+f.Length]
+}
@@ -0,0 +1,160 @@
+package vcu
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The application is linked at 0x08001000 and runs up to the update staging
+// area at 0x08010000.
+const (
+	AppOffset = 0x1000
+	AppEnd    = 0x10000
+)
+
+var (
+	ErrUnknownFirmware     = errors.New("vcu: firmware version could not be detected")
+	ErrUnsupportedFirmware = errors.New("vcu: no layout profile for this firmware")
+	// ErrUnverifiedFirmware is returned by Check for a version read from a
+	// version string rather than matched against a known build. The string
+	// pattern has not been checked against real dumps, so callers should
+	// warn instead of trusting it.
+	ErrUnverifiedFirmware = errors.New("vcu: firmware version taken from a version string, not a known build")
+)
+
+// Detection methods, from most to least reliable.
+const (
+	DetectedByHash   = "hash"
+	DetectedByString = "string"
+)
+
+// builds.txt lists the application hashes of released firmware builds,
+// one "<app sha256> <version>" per line, as printed by info --format json.
+//
+//go:embed builds.txt
+var buildsTxt []byte
+
+// knownBuilds maps the SHA-256 of an application region to its version.
+var knownBuilds = parseBuilds(buildsTxt)
+
+func parseBuilds(data []byte) map[string]string {
+	builds := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			panic("vcu: bad line in builds.txt: " + line)
+		}
+		builds[strings.ToLower(fields[0])] = fields[1]
+	}
+	return builds
+}
+
+// RegisterBuild records the application hash of a known firmware build so
+// DetectFirmware can identify it exactly.
+func RegisterBuild(version, appSHA256 string) {
+	knownBuilds[strings.ToLower(appSHA256)] = version
+}
+
+// Firmware is the result of DetectFirmware.
+type Firmware struct {
+	Version   string
+	Method    string
+	AppSHA256 string
+}
+
+// Supported reports whether a layout profile covers the detected version.
+// It says nothing about how reliably the version was detected; see
+// Verified.
+func (f Firmware) Supported() bool {
+	_, ok := LayoutFor(f.Version, "")
+	return ok
+}
+
+// Verified reports whether the version comes from a known build hash.
+func (f Firmware) Verified() bool {
+	return f.Method == DetectedByHash
+}
+
+// Check returns ErrUnknownFirmware or ErrUnsupportedFirmware when the field
+// offsets cannot be trusted for this firmware, and ErrUnverifiedFirmware
+// when they can only be trusted as far as the version string is.
+func (f Firmware) Check() error {
+	switch {
+	case f.Version == "":
+		return ErrUnknownFirmware
+	case !f.Supported():
+		return fmt.Errorf("%w: %s", ErrUnsupportedFirmware, f.Version)
+	case !f.Verified():
+		return fmt.Errorf("%w: %s", ErrUnverifiedFirmware, f.Version)
+	}
+	return nil
+}
+
+func (f Firmware) String() string {
+	if f.Version == "" {
+		return "unknown"
+	}
+	return f.Version + " (" + f.Method + ")"
+}
+
+// BootSHA256 returns the hex SHA-256 of the bootloader region.
+func (d *Dump) BootSHA256() string {
+	sum := sha256.Sum256(d.data[:AppOffset])
+	return hex.EncodeToString(sum[:])
+}
+
+// AppSHA256 returns the hex SHA-256 of the application region.
+func (d *Dump) AppSHA256() string {
+	sum := sha256.Sum256(d.data[AppOffset:AppEnd])
+	return hex.EncodeToString(sum[:])
+}
+
+var versionPattern = regexp.MustCompile(`[Vv]?([0-9])\.([0-9])\.([0-9])\x00`)
+
+// DetectFirmware fingerprints the application region. An exact hash match
+// against a registered build wins; otherwise the region is searched for a
+// NUL-terminated version string, which is only trusted when it is unique.
+func (d *Dump) DetectFirmware() Firmware {
+	return detectFirmware(d.data[AppOffset:AppEnd])
+}
+
+// StagedFirmware fingerprints the update staging area like DetectFirmware.
+// The area is as large as the application region, so a staged copy of a
+// registered build hashes the same.
+func (d *Dump) StagedFirmware() Firmware {
+	return detectFirmware(d.data[StagingOffset:StagingEnd])
+}
+
+func detectFirmware(region []byte) Firmware {
+	sum := sha256.Sum256(region)
+	fw := Firmware{AppSHA256: hex.EncodeToString(sum[:])}
+	if v, ok := knownBuilds[fw.AppSHA256]; ok {
+		fw.Version, fw.Method = v, DetectedByHash
+		return fw
+	}
+
+	var found []string
+	for _, m := range versionPattern.FindAllSubmatch(region, -1) {
+		v := string(m[1]) + "." + string(m[2]) + "." + string(m[3])
+		if !slices.Contains(found, v) {
+			found = append(found, v)
+		}
+	}
+	if len(found) == 1 {
+		fw.Version, fw.Method = found[0], DetectedByString
+	}
+	return fw
+}
@@ -0,0 +1,54 @@
+package vcu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// appWithVersion returns an application region holding version strings.
+func appWithVersion(versions ...string) []byte {
+	region := bytes.Repeat([]byte{0xFF}, AppEnd-AppOffset)
+	binary.LittleEndian.PutUint32(region, 0x20002000)
+	binary.LittleEndian.PutUint32(region[4:], FlashBase+AppOffset+0x101)
+	at := 0x100
+	for _, v := range versions {
+		at += copy(region[at:], "V"+v+"\x00")
+	}
+	return region
+}
+
+func TestDetectFirmware(t *testing.T) {
+	known := appWithVersion("1.5.6")
+	fw := detectFirmware(known)
+	knownBuilds[fw.AppSHA256] = "1.5.6"
+	t.Cleanup(func() {
+		delete(knownBuilds, fw.AppSHA256)
+	})
+
+	for _, tt := range []struct {
+		name    string
+		region  []byte
+		version string
+		method  string
+		want    error
+	}{
+		{"known build", known, "1.5.6", DetectedByHash, nil},
+		{"version string", appWithVersion("1.5.5"), "1.5.5", DetectedByString, ErrUnverifiedFirmware},
+		{"version string without a layout", appWithVersion("2.0.1"), "2.0.1", DetectedByString, ErrUnsupportedFirmware},
+		{"conflicting strings", appWithVersion("1.5.5", "1.4.8"), "", "", ErrUnknownFirmware},
+		{"nothing", appWithVersion(), "", "", ErrUnknownFirmware},
+	} {
+		fw := detectFirmware(tt.region)
+		if fw.Version != tt.version || fw.Method != tt.method {
+			t.Errorf("%s: detected %q by %q, want %q by %q", tt.name, fw.Version, fw.Method, tt.version, tt.method)
+		}
+		if err := fw.Check(); !errors.Is(err, tt.want) || tt.want == nil && err != nil {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.want)
+		}
+		if fw.Verified() != (tt.method == DetectedByHash) {
+			t.Errorf("%s: Verified = %v", tt.name, fw.Verified())
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package vcu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImageFormat is the file format a flash image is stored in.
+type ImageFormat string
+
+// Image formats. Intel HEX and S-record files carry absolute addresses
+// starting at FlashBase, as exported by ST-Link Utility and
+// STM32CubeProgrammer.
+const (
+	FormatBinary   ImageFormat = "bin"
+	FormatIntelHex ImageFormat = "ihex"
+	FormatSRecord  ImageFormat = "srec"
+)
+
+// recordSize is the number of data bytes per written hex or S-record line.
+const recordSize = 16
+
+var ErrImage = errors.New("vcu: invalid image file")
+
+var imageExtensions = map[string]ImageFormat{
+	".bin":  FormatBinary,
+	".hex":  FormatIntelHex,
+	".ihx":  FormatIntelHex,
+	".s19":  FormatSRecord,
+	".s28":  FormatSRecord,
+	".s37":  FormatSRecord,
+	".srec": FormatSRecord,
+	".mot":  FormatSRecord,
+}
+
+// FormatForPath returns the image format implied by the extension of path,
+// or "" when the extension is not a known image extension.
+func FormatForPath(path string) ImageFormat {
+	return imageExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// DetectFormat returns the format of an image file, from its extension or,
+// failing that, from its content.
+func DetectFormat(path string, data []byte) ImageFormat {
+	if f := FormatForPath(path); f != "" {
+		return f
+	}
+	if len(data) == DumpSize {
+		return FormatBinary
+	}
+	text := bytes.TrimLeft(data, " \t\r\n")
+	switch {
+	case len(text) > 0 && text[0] == ':':
+		return FormatIntelHex
+	case len(text) > 1 && text[0] == 'S' && text[1] >= '0' && text[1] <= '9':
+		return FormatSRecord
+	}
+	return FormatBinary
+}
+
+// ReadImage reads the flash image stored at path in any supported format
+// and returns the raw bytes together with the detected format.
+func ReadImage(path string) ([]byte, ImageFormat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	format := DetectFormat(path, data)
+	image, err := DecodeImage(data, format)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return image, format, nil
+}
+
+// DecodeImage converts a file in the given format to a raw image. Hex and
+// S-record addresses are mapped back to offsets from FlashBase and gaps
+// are filled with 0xFF, the erased flash value. A hex or S-record file
+// with data only in the config sector decodes to ConfigSize bytes.
+func DecodeImage(data []byte, format ImageFormat) ([]byte, error) {
+	switch format {
+	case FormatBinary:
+		return data, nil
+	case FormatIntelHex:
+		return decodeIntelHex(data)
+	case FormatSRecord:
+		return decodeSRecord(data)
+	}
+	return nil, fmt.Errorf("%w: unknown format %q", ErrImage, format)
+}
+
+// EncodeImage converts a raw image starting at offset to the given format.
+// Intel HEX and S-records use DefaultIntelHexStyle and DefaultSRecordStyle.
+// Every byte is written, including erased ones, so a programmer flashing
+// the file leaves no stale data behind.
+func EncodeImage(image []byte, offset int, format ImageFormat) ([]byte, error) {
+	switch format {
+	case FormatBinary:
+		return image, nil
+	case FormatIntelHex:
+		return EncodeIntelHex(image, offset, DefaultIntelHexStyle)
+	case FormatSRecord:
+		return EncodeSRecord(image, offset, DefaultSRecordStyle)
+	}
+	return nil, fmt.Errorf("%w: unknown format %q", ErrImage, format)
+}
+
+// imageBuilder collects records into an erased image.
+type imageBuilder struct {
+	image []byte
+	count int
+	low   int64 // lowest offset written
+}
+
+func newImageBuilder() *imageBuilder {
+	return &imageBuilder{image: bytes.Repeat([]byte{0xFF}, DumpSize), low: DumpSize}
+}
+
+// result returns the image, cut down to the config sector when nothing
+// before it was written.
+func (b *imageBuilder) result() ([]byte, error) {
+	if b.count == 0 {
+		return nil, fmt.Errorf("%w: no data records", ErrImage)
+	}
+	if b.low >= ConfigAOffset {
+		return b.image[ConfigAOffset:], nil
+	}
+	return b.image, nil
+}
+
+// put stores data at an absolute address. Addresses below DumpSize are
+// taken as offsets, for files exported relative to the start of flash.
+func (b *imageBuilder) put(line int, addr uint32, data []byte) error {
+	offset := int64(addr)
+	if addr >= FlashBase {
+		offset -= FlashBase
+	}
+	if offset+int64(len(data)) > DumpSize {
+		return fmt.Errorf("%w: line %d: address 0x%08X outside the 0x%X byte flash", ErrImage, line, addr, DumpSize)
+	}
+	copy(b.image[offset:], data)
+	b.count += len(data)
+	if len(data) > 0 {
+		b.low = min(b.low, offset)
+	}
+	return nil
+}
+
+// records splits a text image into lines, skipping blank ones.
+func records(data []byte, fn func(line int, rec string) (bool, error)) error {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		rec := strings.TrimSpace(s.Text())
+		if rec == "" {
+			continue
+		}
+		done, err := fn(line, rec)
+		if err != nil || done {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: missing end-of-file record", ErrImage)
+}
+
+// decodeRecordBytes decodes the hex digits of a record.
+func decodeRecordBytes(line int, digits string) ([]byte, error) {
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("%w: line %d: %v", ErrImage, line, err)
+	}
+	return b, nil
+}
+
+func decodeIntelHex(data []byte) ([]byte, error) {
+	b := newImageBuilder()
+	var base uint32
+	err := records(data, func(line int, rec string) (bool, error) {
+		if rec[0] != ':' {
+			return false, fmt.Errorf("%w: line %d: record does not start with ':'", ErrImage, line)
+		}
+		raw, err := decodeRecordBytes(line, rec[1:])
+		if err != nil {
+			return false, err
+		}
+		if len(raw) < 5 || len(raw) != int(raw[0])+5 {
+			return false, fmt.Errorf("%w: line %d: bad record length", ErrImage, line)
+		}
+		var sum byte
+		for _, c := range raw {
+			sum += c
+		}
+		if sum != 0 {
+			return false, fmt.Errorf("%w: line %d: checksum mismatch", ErrImage, line)
+		}
+
+		addr := uint32(raw[1])<<8 | uint32(raw[2])
+		payload := raw[4 : len(raw)-1]
+		switch raw[3] {
+		case 0x00:
+			return false, b.put(line, base+addr, payload)
+		case 0x01:
+			return true, nil
+		case 0x02:
+			if len(payload) != 2 {
+				return false, fmt.Errorf("%w: line %d: bad segment address record", ErrImage, line)
+			}
+			base = (uint32(payload[0])<<8 | uint32(payload[1])) << 4
+		case 0x04:
+			if len(payload) != 2 {
+				return false, fmt.Errorf("%w: line %d: bad linear address record", ErrImage, line)
+			}
+			base = (uint32(payload[0])<<8 | uint32(payload[1])) << 16
+		case 0x03, 0x05:
+			// Start addresses do not affect the image.
+		default:
+			return false, fmt.Errorf("%w: line %d: unknown record type %02X", ErrImage, line, raw[3])
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.result()
+}
+
+// IntelHexStyle is how an Intel HEX file addresses its data: the record
+// type that sets the upper address bits and whether addresses start at
+// FlashBase or at 0. Segment addresses reach only the first megabyte, so
+// they are always relative.
+type IntelHexStyle struct {
+	Extended byte // 0x04 for extended linear, 0x02 for extended segment addresses
+	Absolute bool
+}
+
+// DefaultIntelHexStyle is extended linear addresses from FlashBase, as
+// exported by STM32CubeProgrammer.
+var DefaultIntelHexStyle = IntelHexStyle{Extended: 0x04, Absolute: true}
+
+// DetectIntelHexStyle returns the style of the first data record in an
+// Intel HEX file, or DefaultIntelHexStyle when there is none.
+func DetectIntelHexStyle(data []byte) IntelHexStyle {
+	style := IntelHexStyle{Extended: 0x04}
+	var base uint32
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		rec := strings.TrimSpace(s.Text())
+		if len(rec) < 1 || rec[0] != ':' {
+			continue
+		}
+		raw, err := hex.DecodeString(rec[1:])
+		if err != nil || len(raw) < 5 || len(raw) != int(raw[0])+5 {
+			break
+		}
+		switch payload := raw[4 : len(raw)-1]; {
+		case raw[3] == 0x00:
+			style.Absolute = base+(uint32(raw[1])<<8|uint32(raw[2])) >= FlashBase
+			return style
+		case raw[3] == 0x02 && len(payload) == 2:
+			style.Extended = 0x02
+			base = (uint32(payload[0])<<8 | uint32(payload[1])) << 4
+		case raw[3] == 0x04 && len(payload) == 2:
+			style.Extended = 0x04
+			base = (uint32(payload[0])<<8 | uint32(payload[1])) << 16
+		}
+	}
+	return DefaultIntelHexStyle
+}
+
+// EncodeIntelHex converts a raw image starting at offset to Intel HEX of
+// the given style.
+func EncodeIntelHex(image []byte, start int, style IntelHexStyle) ([]byte, error) {
+	switch {
+	case style.Extended != 0x02 && style.Extended != 0x04:
+		return nil, fmt.Errorf("%w: record type %02X is not an address record", ErrImage, style.Extended)
+	case style.Extended == 0x02 && style.Absolute:
+		return nil, fmt.Errorf("%w: segment addresses cannot reach 0x%08X", ErrImage, FlashBase)
+	}
+	base := 0
+	if style.Absolute {
+		base = FlashBase
+	}
+
+	var buf bytes.Buffer
+	record := func(addr uint16, typ byte, data []byte) {
+		raw := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}, data...)
+		var sum byte
+		for _, c := range raw {
+			sum += c
+		}
+		raw = append(raw, -sum)
+		buf.WriteString(":" + strings.ToUpper(hex.EncodeToString(raw)) + "\n")
+	}
+
+	upper := -1
+	for offset := 0; offset < len(image); offset += recordSize {
+		addr := uint32(base + start + offset)
+		if int(addr>>16) != upper {
+			upper = int(addr >> 16)
+			// A segment is the upper bits shifted by 4 rather than 16.
+			v := upper
+			if style.Extended == 0x02 {
+				v = upper << 12
+			}
+			record(0, style.Extended, []byte{byte(v >> 8), byte(v)})
+		}
+		record(uint16(addr), 0x00, image[offset:min(offset+recordSize, len(image))])
+	}
+	record(0, 0x01, nil)
+	return buf.Bytes(), nil
+}
+
+// srecAddressSize is the address length in bytes of each S-record type.
+var srecAddressSize = map[byte]int{
+	'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2,
+}
+
+func decodeSRecord(data []byte) ([]byte, error) {
+	b := newImageBuilder()
+	err := records(data, func(line int, rec string) (bool, error) {
+		if len(rec) < 2 || rec[0] != 'S' || srecAddressSize[rec[1]] == 0 {
+			return false, fmt.Errorf("%w: line %d: not an S-record", ErrImage, line)
+		}
+		size := srecAddressSize[rec[1]]
+		raw, err := decodeRecordBytes(line, rec[2:])
+		if err != nil {
+			return false, err
+		}
+		if len(raw) < size+2 || len(raw) != int(raw[0])+1 {
+			return false, fmt.Errorf("%w: line %d: bad record length", ErrImage, line)
+		}
+		var sum byte
+		for _, c := range raw {
+			sum += c
+		}
+		if sum != 0xFF {
+			return false, fmt.Errorf("%w: line %d: checksum mismatch", ErrImage, line)
+		}
+
+		var addr uint32
+		for _, c := range raw[1 : 1+size] {
+			addr = addr<<8 | uint32(c)
+		}
+		switch rec[1] {
+		case '1', '2', '3':
+			return false, b.put(line, addr, raw[1+size:len(raw)-1])
+		case '7', '8', '9':
+			return true, nil
+		}
+		// S0 header and S5/S6 record counts carry no image data.
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.result()
+}
+
+// SRecordStyle is how an S-record file addresses its data: the data
+// record type and whether addresses start at FlashBase or at 0. S1 and S2
+// records are too short for FlashBase, so they are always relative.
+type SRecordStyle struct {
+	Type     byte // '1', '2' or '3'
+	Absolute bool
+}
+
+// DefaultSRecordStyle is S3 records at absolute addresses, as exported by
+// STM32CubeProgrammer.
+var DefaultSRecordStyle = SRecordStyle{Type: '3', Absolute: true}
+
+// srecEnd is the end record matching each data record type.
+var srecEnd = map[byte]byte{'1': '9', '2': '8', '3': '7'}
+
+// DetectSRecordStyle returns the style of the first data record in an
+// S-record file, or DefaultSRecordStyle when there is none.
+func DetectSRecordStyle(data []byte) SRecordStyle {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		rec := strings.TrimSpace(s.Text())
+		if len(rec) < 2 || rec[0] != 'S' || srecEnd[rec[1]] == 0 {
+			continue
+		}
+		size := srecAddressSize[rec[1]]
+		raw, err := hex.DecodeString(rec[2:])
+		if err != nil || len(raw) < 1+size {
+			break
+		}
+		var addr uint32
+		for _, c := range raw[1 : 1+size] {
+			addr = addr<<8 | uint32(c)
+		}
+		return SRecordStyle{Type: rec[1], Absolute: addr >= FlashBase}
+	}
+	return DefaultSRecordStyle
+}
+
+// EncodeSRecord converts a raw image starting at offset to S-records of
+// the given style. It fails when the addresses do not fit the record type.
+func EncodeSRecord(image []byte, start int, style SRecordStyle) ([]byte, error) {
+	size, ok := srecAddressSize[style.Type]
+	if !ok || srecEnd[style.Type] == 0 {
+		return nil, fmt.Errorf("%w: S%c is not a data record", ErrImage, style.Type)
+	}
+	base := 0
+	if style.Absolute {
+		base = FlashBase
+	}
+	if last := uint64(base + start + len(image)); last > 1<<(8*size) {
+		return nil, fmt.Errorf("%w: address 0x%X does not fit S%c records", ErrImage, last-1, style.Type)
+	}
+
+	var buf bytes.Buffer
+	record := func(typ byte, addr uint32, data []byte) {
+		n := srecAddressSize[typ]
+		raw := []byte{byte(n + len(data) + 1)}
+		for i := n - 1; i >= 0; i-- {
+			raw = append(raw, byte(addr>>(8*i)))
+		}
+		raw = append(raw, data...)
+		var sum byte
+		for _, c := range raw {
+			sum += c
+		}
+		raw = append(raw, ^sum)
+		buf.WriteString("S" + string(typ) + strings.ToUpper(hex.EncodeToString(raw)) + "\n")
+	}
+
+	record('0', 0, nil)
+	for offset := 0; offset < len(image); offset += recordSize {
+		record(style.Type, uint32(base+start+offset), image[offset:min(offset+recordSize, len(image))])
+	}
+	record(srecEnd[style.Type], uint32(base), nil)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+package vcu
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIntelHexRoundTrip(t *testing.T) {
+	full := mirroredDump(t)
+	for _, style := range []IntelHexStyle{
+		DefaultIntelHexStyle,
+		{Extended: 0x04},
+		{Extended: 0x02},
+	} {
+		for _, start := range []int{0, ConfigAOffset} {
+			image := full[start:]
+			data, err := EncodeIntelHex(image, start, style)
+			if err != nil {
+				t.Fatalf("%+v at 0x%X: %v", style, start, err)
+			}
+			checkRoundTrip(t, data, FormatIntelHex, image, start)
+			if got := DetectIntelHexStyle(data); got != style {
+				t.Errorf("%+v at 0x%X: detected %+v", style, start, got)
+			}
+		}
+	}
+
+	if _, err := EncodeIntelHex(full, 0, IntelHexStyle{Extended: 0x02, Absolute: true}); !errors.Is(err, ErrImage) {
+		t.Errorf("absolute segment addresses: %v, want ErrImage", err)
+	}
+}
+
+func TestSRecordRoundTrip(t *testing.T) {
+	full := mirroredDump(t)
+	for _, style := range []SRecordStyle{
+		DefaultSRecordStyle,
+		{Type: '3'},
+		{Type: '2'},
+	} {
+		for _, start := range []int{0, ConfigAOffset} {
+			image := full[start:]
+			data, err := EncodeSRecord(image, start, style)
+			if err != nil {
+				t.Fatalf("%+v at 0x%X: %v", style, start, err)
+			}
+			checkRoundTrip(t, data, FormatSRecord, image, start)
+			if got := DetectSRecordStyle(data); got != style {
+				t.Errorf("%+v at 0x%X: detected %+v", style, start, got)
+			}
+		}
+	}
+
+	// 16-bit S1 addresses end below the config sector.
+	if _, err := EncodeSRecord(full[ConfigAOffset:], ConfigAOffset, SRecordStyle{Type: '1'}); !errors.Is(err, ErrImage) {
+		t.Errorf("S1 config sector: %v, want ErrImage", err)
+	}
+}
+
+// checkRoundTrip decodes data, then loads it from a file and checks that
+// the dump encodes back to the same text.
+func checkRoundTrip(t *testing.T, data []byte, format ImageFormat, image []byte, start int) {
+	t.Helper()
+	decoded, err := DecodeImage(data, format)
+	if err != nil || !bytes.Equal(decoded, image) {
+		t.Errorf("%s at 0x%X: decoded %d bytes, %v; want the %d-byte image", format, start, len(decoded), err, len(image))
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "vcu."+string(format))
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Start() != start {
+		t.Errorf("%s: loaded at 0x%X, want 0x%X", format, d.Start(), start)
+	}
+	if got, err := d.Encode(format); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("%s at 0x%X: re-encoded file differs from the one read (%v)", format, start, err)
+	}
+}
+
+func TestDecodeGaps(t *testing.T) {
+	full := mirroredDump(t)
+	for _, format := range []ImageFormat{FormatIntelHex, FormatSRecord} {
+		// Everything but config B: the part before it without its end
+		// record, then the part after it.
+		before, err := EncodeImage(full[:ConfigBOffset], 0, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := EncodeImage(full[DescriptorOffset:], DescriptorOffset, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before = before[:bytes.LastIndexByte(before[:len(before)-1], '\n')+1]
+
+		decoded, err := DecodeImage(append(before, after...), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		want := bytes.Clone(full)
+		copy(want[ConfigBOffset:DescriptorOffset], bytes.Repeat([]byte{0xFF}, DescriptorOffset-ConfigBOffset))
+		if !bytes.Equal(decoded, want) {
+			t.Errorf("%s: the gap is not erased", format)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		format ImageFormat
+		data   string
+	}{
+		{"hex bad checksum", FormatIntelHex, ":020000040801F1\n:0400000001020304F3\n:00000001FF\n"},
+		{"hex short record", FormatIntelHex, ":0201\n:00000001FF\n"},
+		{"hex length mismatch", FormatIntelHex, ":0400000001020304\n:00000001FF\n"},
+		{"hex without colon", FormatIntelHex, "0400000001020304F2\n:00000001FF\n"},
+		{"hex not hex", FormatIntelHex, ":04000000010203ZZF2\n:00000001FF\n"},
+		{"hex unknown record type", FormatIntelHex, ":00000006FA\n:00000001FF\n"},
+		{"hex missing end", FormatIntelHex, ":0400000001020304F2\n"},
+		{"hex no data", FormatIntelHex, ":00000001FF\n"},
+		{"hex outside flash", FormatIntelHex, ":020000040802F0\n:0400000001020304F2\n:00000001FF\n"},
+		{"srec bad checksum", FormatSRecord, "S10700000102030400\nS9030000FC\n"},
+		{"srec short record", FormatSRecord, "S101\nS9030000FC\n"},
+		{"srec length mismatch", FormatSRecord, "S1080000010203F1\nS9030000FC\n"},
+		{"srec unknown type", FormatSRecord, "S4030000FC\nS9030000FC\n"},
+		{"srec missing end", FormatSRecord, "S107000001020304EE\n"},
+	} {
+		if _, err := DecodeImage([]byte(tt.data), tt.format); !errors.Is(err, ErrImage) {
+			t.Errorf("%s: %v, want ErrImage", tt.name, err)
+		}
+	}
+
+	// The valid records the cases above are built from.
+	for _, data := range []string{
+		":0400000001020304F2\n:00000001FF\n",
+		"S107000001020304EE\nS9030000FC\n",
+	} {
+		format := DetectFormat("", []byte(data))
+		if image, err := DecodeImage([]byte(data), format); err != nil || !bytes.Equal(image[:4], []byte{1, 2, 3, 4}) {
+			t.Errorf("%s: % X, %v", format, image[:min(len(image), 4)], err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package vcu
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JournalVersion is the format version written to new journals.
+const JournalVersion = 1
+
+var (
+	ErrJournal         = errors.New("vcu: invalid change journal")
+	ErrJournalMismatch = errors.New("vcu: dump does not match change journal")
+)
+
+// JournalFile identifies a dump by path and content hash.
+type JournalFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// JournalField is the before and after value of a known field.
+type JournalField struct {
+	Name    string `json:"name"`
+	Offset  int    `json:"offset"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Changed bool   `json:"changed"`
+}
+
+// JournalRange is a run of changed bytes, hex encoded.
+type JournalRange struct {
+	Offset int    `json:"offset"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// Journal records what a patch changed so it can be explained and undone.
+type Journal struct {
+	Version   int            `json:"version"`
+	Time      time.Time      `json:"time"`
+	User      string         `json:"user,omitempty"`
+	Host      string         `json:"host,omitempty"`
+	Input     JournalFile    `json:"input"`
+	Output    JournalFile    `json:"output"`
+	Firmware  string         `json:"firmware"`
+	Layout    string         `json:"layout"`
+	KeySource string         `json:"key_source,omitempty"`
+	Fields    []JournalField `json:"fields"`
+	Ranges    []JournalRange `json:"ranges"`
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewJournal compares the original and patched images. Paths, user and key
+// source are left for the caller to fill in.
+func NewJournal(original, patched *Dump) *Journal {
+	j := &Journal{
+		Version:  JournalVersion,
+		Time:     time.Now().UTC(),
+		Input:    JournalFile{SHA256: sha256Hex(original.Bytes())},
+		Output:   JournalFile{SHA256: sha256Hex(patched.Bytes())},
+		Firmware: original.DetectFirmware().String(),
+		Layout:   patched.layout.Name,
+		Fields:   []JournalField{},
+		Ranges:   []JournalRange{},
+	}
+
+	for _, f := range patched.Fields() {
+		old, cur := original.fieldBytes(f), patched.fieldBytes(f)
+		j.Fields = append(j.Fields, JournalField{
+			Name:    f.Name,
+			Offset:  f.Offset,
+			Old:     f.Format(old),
+			New:     f.Format(cur),
+			Changed: !bytes.Equal(old, cur),
+		})
+	}
+
+	for i := 0; i < DumpSize; i++ {
+		if original.data[i] == patched.data[i] {
+			continue
+		}
+		j0 := i
+		for i < DumpSize && original.data[i] != patched.data[i] {
+			i++
+		}
+		j.Ranges = append(j.Ranges, JournalRange{
+			Offset: j0,
+			Old:    strings.ToUpper(hex.EncodeToString(original.data[j0:i])),
+			New:    strings.ToUpper(hex.EncodeToString(patched.data[j0:i])),
+		})
+	}
+	return j
+}
+
+// JournalPath returns the journal file name for an output dump: the
+// extension is replaced with ".json".
+func JournalPath(output string) string {
+	ext := filepath.Ext(output)
+	if strings.EqualFold(ext, ".json") {
+		return output + ".json"
+	}
+	return strings.TrimSuffix(output, ext) + ".json"
+}
+
+// Marshal encodes the journal as indented JSON.
+func (j *Journal) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ReadJournal loads a journal encoded by Marshal.
+func ReadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{}
+	if err = json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrJournal, path, err)
+	}
+	if j.Version != JournalVersion {
+		return nil, fmt.Errorf("%w: %s: unsupported version %d", ErrJournal, path, j.Version)
+	}
+	return j, nil
+}
+
+// Undo restores the original image from a patched one. The patched dump
+// must match the journal's output hash, and the result is checked against
+// the input hash before it is returned.
+func (j *Journal) Undo(patched *Dump) (*Dump, error) {
+	if got := sha256Hex(patched.Bytes()); got != j.Output.SHA256 {
+		return nil, fmt.Errorf("%w: patched dump is %s, journal expects %s", ErrJournalMismatch, got, j.Output.SHA256)
+	}
+
+	restored := patched.Clone()
+	for _, r := range j.Ranges {
+		old, err := hex.DecodeString(r.Old)
+		if err != nil {
+			return nil, fmt.Errorf("%w: range at 0x%05X: %v", ErrJournal, r.Offset, err)
+		}
+		if r.Offset < 0 || r.Offset+len(old) > DumpSize {
+			return nil, fmt.Errorf("%w: range at 0x%05X out of bounds", ErrJournal, r.Offset)
+		}
+		copy(restored.data[r.Offset:], old)
+	}
+
+	if got := sha256Hex(restored.Bytes()); got != j.Input.SHA256 {
+		return nil, fmt.Errorf("%w: restored dump is %s, journal expects %s", ErrJournalMismatch, got, j.Input.SHA256)
+	}
+	return restored, nil
+}
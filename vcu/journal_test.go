@@ -0,0 +1,113 @@
+package vcu
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// patchedPair returns a dump and a copy with a new serial and mileage.
+func patchedPair(t *testing.T) (original, patched *Dump) {
+	t.Helper()
+	original, err := Parse(mirroredDump(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched = original.Clone()
+	if _, err = patched.SetSerial("1CGCC9999C9999"); err != nil {
+		t.Fatal(err)
+	}
+	if err = patched.SetMileage(1234); err != nil {
+		t.Fatal(err)
+	}
+	return original, patched
+}
+
+func TestNewJournal(t *testing.T) {
+	original, patched := patchedPair(t)
+	j := NewJournal(original, patched)
+
+	// Two serials, two mileages, no speed or key.
+	changed := map[string]bool{}
+	for _, f := range j.Fields {
+		if f.Changed {
+			changed[f.Name] = true
+		}
+	}
+	if len(changed) != 4 || !changed["serial 1"] || !changed["mileage A"] {
+		t.Errorf("changed fields %v, want both serials and both mileages", changed)
+	}
+	if len(j.Ranges) == 0 {
+		t.Error("no changed ranges")
+	}
+
+	// The journal survives a round trip through its file.
+	data, err := j.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "vcu.patched.json")
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Output.SHA256 != j.Output.SHA256 || len(read.Ranges) != len(j.Ranges) {
+		t.Errorf("read back %+v, want %+v", read, j)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	original, patched := patchedPair(t)
+	j := NewJournal(original, patched)
+
+	restored, err := j.Undo(patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored.Bytes(), original.Bytes()) {
+		t.Error("undo did not restore the original bytes")
+	}
+	if sn := patched.Serials(); sn[0].Value != "1CGCC9999C9999" {
+		t.Error("undo changed the patched dump")
+	}
+}
+
+func TestUndoRefused(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		change func(j *Journal, patched *Dump)
+		want   error
+	}{
+		{"file edited since the patch", func(_ *Journal, patched *Dump) {
+			if err := patched.SetSpeed(20); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrJournalMismatch},
+		{"ranges do not lead back to the input", func(j *Journal, _ *Dump) {
+			j.Ranges = j.Ranges[1:]
+		}, ErrJournalMismatch},
+		{"range not hex", func(j *Journal, _ *Dump) {
+			j.Ranges[0].Old = "XY"
+		}, ErrJournal},
+		{"range out of bounds", func(j *Journal, _ *Dump) {
+			j.Ranges[0].Offset = DumpSize - 1
+		}, ErrJournal},
+	} {
+		original, patched := patchedPair(t)
+		j := NewJournal(original, patched)
+		tt.change(j, patched)
+		before := bytes.Clone(patched.Bytes())
+
+		if restored, err := j.Undo(patched); !errors.Is(err, tt.want) || restored != nil {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+		if !bytes.Equal(patched.Bytes(), before) {
+			t.Errorf("%s: refused undo changed the patched dump", tt.name)
+		}
+	}
+}
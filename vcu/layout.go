@@ -0,0 +1,220 @@
+package vcu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrLayout = errors.New("vcu: invalid layout profile")
+
+// Offset is a position in the dump. Profile files may write it as a number
+// or as a "0x"-prefixed hex string.
+type Offset int
+
+func (o *Offset) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int
+		if err = json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*o = Offset(n)
+		return nil
+	}
+	return o.parse(s)
+}
+
+func (o Offset) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *Offset) UnmarshalYAML(node *yaml.Node) error {
+	return o.parse(node.Value)
+}
+
+func (o Offset) MarshalYAML() (any, error) {
+	return o.String(), nil
+}
+
+func (o *Offset) parse(s string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 0, 32)
+	if err != nil {
+		return fmt.Errorf("%w: bad offset %q", ErrLayout, s)
+	}
+	*o = Offset(n)
+	return nil
+}
+
+func (o Offset) String() string {
+	return fmt.Sprintf("0x%05X", int(o))
+}
+
+// Layout describes where a firmware keeps the editable fields.
+type Layout struct {
+	Name string `json:"name" yaml:"name"`
+	// Versions lists the firmware versions the profile applies to.
+	Versions []string `json:"versions" yaml:"versions"`
+	// Bootloaders optionally lists SHA-256 hashes of the bootloader region,
+	// used when the firmware version cannot be detected.
+	Bootloaders []string `json:"bootloaders,omitempty" yaml:"bootloaders,omitempty"`
+
+	SerialPrefix string   `json:"serial_prefix" yaml:"serial_prefix"`
+	SkipSerial   string   `json:"skip_serial,omitempty" yaml:"skip_serial,omitempty"`
+	SerialLength int      `json:"serial_length" yaml:"serial_length"`
+	MileageA     Offset   `json:"mileage_a" yaml:"mileage_a"`
+	MileageB     Offset   `json:"mileage_b" yaml:"mileage_b"`
+	Speeds       []Offset `json:"speeds" yaml:"speeds"`
+	KeyOffset    Offset   `json:"key_offset" yaml:"key_offset"`
+	KeyLength    int      `json:"key_length" yaml:"key_length"`
+}
+
+// DefaultLayout is the layout shared by all firmwares the tool was tested on.
+var DefaultLayout = &Layout{
+	Name:         "g3",
+	Versions:     []string{"1.4.5", "1.4.8", "1.5.4", "1.5.5", "1.5.6"},
+	SerialPrefix: "1CG",
+	SkipSerial:   "1CGC0000000001",
+	SerialLength: 14,
+	MileageA:     0x1F0C4,
+	MileageB:     0x1F4C4,
+	Speeds:       []Offset{0x1F08D, 0x1F091, 0x1F48D, 0x1F491},
+	KeyOffset:    0x1F5B4,
+	KeyLength:    12,
+}
+
+var layouts = []*Layout{DefaultLayout}
+
+// Validate checks that every field fits in a dump.
+func (l *Layout) Validate() error {
+	inside := func(o Offset, n int) bool {
+		return o >= 0 && int(o)+n <= DumpSize
+	}
+	switch {
+	case l.Name == "":
+		return fmt.Errorf("%w: missing name", ErrLayout)
+	case l.SerialLength <= 0 || len(l.SerialPrefix) == 0 || len(l.SerialPrefix) > l.SerialLength:
+		return fmt.Errorf("%w %s: bad serial prefix or length", ErrLayout, l.Name)
+	case l.SkipSerial != "" && len(l.SkipSerial) != l.SerialLength:
+		return fmt.Errorf("%w %s: skip serial must be %d characters", ErrLayout, l.Name, l.SerialLength)
+	case !inside(l.MileageA, 2) || !inside(l.MileageB, 2):
+		return fmt.Errorf("%w %s: mileage offset out of range", ErrLayout, l.Name)
+	case len(l.Speeds) == 0:
+		return fmt.Errorf("%w %s: no speed offsets", ErrLayout, l.Name)
+	case l.KeyLength <= 0 || !inside(l.KeyOffset, l.KeyLength):
+		return fmt.Errorf("%w %s: key out of range", ErrLayout, l.Name)
+	}
+	for _, o := range l.Speeds {
+		if !inside(o, 1) {
+			return fmt.Errorf("%w %s: speed offset %s out of range", ErrLayout, l.Name, o)
+		}
+	}
+	return nil
+}
+
+// RegisterLayout adds l to the registry. A profile with the same name
+// replaces the existing one, so files can override built-in profiles.
+func RegisterLayout(l *Layout) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	for i, existing := range layouts {
+		if existing.Name == l.Name {
+			layouts[i] = l
+			return nil
+		}
+	}
+	layouts = append(layouts, l)
+	return nil
+}
+
+// Layouts returns the registered profiles.
+func Layouts() []*Layout {
+	return slices.Clone(layouts)
+}
+
+// LookupLayout returns the profile called name.
+func LookupLayout(name string) (*Layout, bool) {
+	for _, l := range layouts {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// LayoutFor returns the profile for a firmware version, or for a bootloader
+// hash when the version is unknown. Later registrations take precedence.
+func LayoutFor(version, bootSHA256 string) (*Layout, bool) {
+	for i := len(layouts) - 1; i >= 0; i-- {
+		if version != "" && slices.Contains(layouts[i].Versions, version) {
+			return layouts[i], true
+		}
+	}
+	for i := len(layouts) - 1; i >= 0; i-- {
+		if bootSHA256 != "" && slices.Contains(layouts[i].Bootloaders, bootSHA256) {
+			return layouts[i], true
+		}
+	}
+	return nil, false
+}
+
+// LoadLayoutFile reads a JSON or YAML profile.
+func LoadLayoutFile(path string) (*Layout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Layout{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, l)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, l)
+	default:
+		return nil, fmt.Errorf("%w: %s: unknown profile extension", ErrLayout, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrLayout, path, err)
+	}
+	if err = l.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// LoadLayoutDir registers every profile file in dir. A missing directory is
+// not an error.
+func LoadLayoutDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		l, err := LoadLayoutFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err = RegisterLayout(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package vcu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+)
+
+// Flash geometry of the STM32 the VCU runs on. Offsets in a dump are
+// relative to FlashBase; erase pages are 1 KiB.
+const (
+	FlashBase = 0x08000000
+	PageSize  = 0x400
+
+	StagingOffset    = AppEnd
+	StagingEnd       = ConfigAOffset
+	ConfigAOffset    = 0x1F000
+	ConfigBOffset    = 0x1F400
+	DescriptorOffset = 0x1F800
+	ReservedOffset   = 0x1FC00
+)
+
+// Region names.
+const (
+	RegionBootloader = "bootloader"
+	RegionApp        = "application"
+	RegionStaging    = "update staging"
+	RegionConfigA    = "config A"
+	RegionConfigB    = "config B"
+	RegionDescriptor = "update descriptor"
+	RegionReserved   = "reserved"
+)
+
+// Region is a named range of the flash image, as offsets from FlashBase.
+type Region struct {
+	Name  string
+	Start int
+	End   int // exclusive
+}
+
+func (r Region) Contains(offset int) bool {
+	return offset >= r.Start && offset < r.End
+}
+
+func (r Region) Size() int {
+	return r.End - r.Start
+}
+
+// Address returns the absolute flash address of the region.
+func (r Region) Address() uint32 {
+	return FlashBase + uint32(r.Start)
+}
+
+// Regions is the memory map of the image as used by the bootloader: it
+// boots the application at 0x08001000 and, when the descriptor at
+// 0x0801F800 asks for it, copies the staging area over the application.
+var Regions = []Region{
+	{RegionBootloader, 0x00000, AppOffset},
+	{RegionApp, AppOffset, AppEnd},
+	{RegionStaging, StagingOffset, StagingEnd},
+	{RegionConfigA, ConfigAOffset, ConfigBOffset},
+	{RegionConfigB, ConfigBOffset, DescriptorOffset},
+	{RegionDescriptor, DescriptorOffset, ReservedOffset},
+	{RegionReserved, ReservedOffset, DumpSize},
+}
+
+// RegionAt returns the region containing offset.
+func RegionAt(offset int) (Region, bool) {
+	for _, r := range Regions {
+		if r.Contains(offset) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// LookupRegion returns the region called name.
+func LookupRegion(name string) (Region, bool) {
+	for _, r := range Regions {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// RegionInfo describes the contents of a region in a dump.
+type RegionInfo struct {
+	Region
+	Erased      bool
+	ErasedBytes int
+	SHA256      string
+	Fields      []Field
+}
+
+// RegionBytes returns the bytes of r in the dump.
+func (d *Dump) RegionBytes(r Region) []byte {
+	return d.data[r.Start:r.End]
+}
+
+// Map describes every region of the dump. Regions a config-only dump does
+// not contain are left out.
+func (d *Dump) Map() []RegionInfo {
+	fields := d.Fields()
+	slices.SortStableFunc(fields, func(x, y Field) int { return x.Offset - y.Offset })
+	infos := make([]RegionInfo, 0, len(Regions))
+	for _, r := range Regions {
+		if r.Start < d.start {
+			continue
+		}
+		b := d.RegionBytes(r)
+		sum := sha256.Sum256(b)
+		info := RegionInfo{Region: r, SHA256: hex.EncodeToString(sum[:])}
+		for _, v := range b {
+			if v == 0xFF {
+				info.ErasedBytes++
+			}
+		}
+		info.Erased = info.ErasedBytes == len(b)
+		for _, f := range fields {
+			if r.Contains(f.Offset) {
+				info.Fields = append(info.Fields, f)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
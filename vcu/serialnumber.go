@@ -0,0 +1,124 @@
+package vcu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// serialScheme is the character class of each position after the model
+// prefix on the serials seen so far, e.g. 1CG C C 1234 C 1234: two
+// letters, a four digit batch, a letter and a four digit unit number. It
+// is not documented, so a serial that does not follow it is only warned
+// about.
+const serialScheme = "AA0000A0000"
+
+// SerialNumber is a decoded serial. Only the model code is documented by
+// Ninebot; the remaining groups are named after how they vary between
+// scooters and are empty when the serial does not follow serialScheme.
+type SerialNumber struct {
+	Raw    string
+	Model  string // e.g. "1CG" for the MAX G3
+	Series string // two letters after the model, e.g. "CC"
+	Batch  string // four digit production batch
+	Line   string // letter before the unit number
+	Unit   string // four digit unit number within the batch
+}
+
+func (s SerialNumber) String() string {
+	return s.Raw
+}
+
+// SerialError reports the first character of a serial that does not fit
+// the scheme. It matches ErrSerialFormat with errors.Is.
+type SerialError struct {
+	Serial string
+	Pos    int  // 0-based, -1 for length errors
+	Char   rune // the offending character
+	Want   string
+}
+
+func (e *SerialError) Error() string {
+	if e.Pos < 0 {
+		return fmt.Sprintf("vcu: invalid serial number %q: %s", e.Serial, e.Want)
+	}
+	return fmt.Sprintf("vcu: invalid serial number %q: position %d is %q, want %s",
+		e.Serial, e.Pos+1, e.Char, e.Want)
+}
+
+func (e *SerialError) Is(target error) bool {
+	return target == ErrSerialFormat
+}
+
+// ParseSerial checks what is documented about a serial, its length and
+// the model prefix of layout l, and decodes it. Serials that do not follow
+// serialScheme are accepted; Warnings reports them.
+func ParseSerial(s string, l *Layout) (SerialNumber, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	// Checked per rune so look-alikes such as a Cyrillic "С" are reported
+	// at the right position.
+	for i, c := range []rune(s) {
+		if c > 0x7F || !isUpper(byte(c)) && !isDigit(byte(c)) {
+			return SerialNumber{}, &SerialError{Serial: s, Pos: i, Char: c, Want: "a letter A–Z or digit 0–9"}
+		}
+	}
+	if len(s) != l.SerialLength {
+		return SerialNumber{}, &SerialError{Serial: s, Pos: -1, Want: fmt.Sprintf("must be %d characters", l.SerialLength)}
+	}
+	for i := 0; i < len(l.SerialPrefix); i++ {
+		if s[i] != l.SerialPrefix[i] {
+			return SerialNumber{}, &SerialError{Serial: s, Pos: i, Char: rune(s[i]), Want: fmt.Sprintf("model prefix %q", l.SerialPrefix)}
+		}
+	}
+
+	sn := SerialNumber{Raw: s, Model: l.SerialPrefix}
+	if rest := s[len(l.SerialPrefix):]; schemeMismatch(rest) < 0 {
+		sn.Series, sn.Batch, sn.Line, sn.Unit = rest[0:2], rest[2:6], rest[6:7], rest[7:11]
+	}
+	return sn, nil
+}
+
+// schemeMismatch returns the first position of rest that does not follow
+// serialScheme, or -1 when all do.
+func schemeMismatch(rest string) int {
+	if len(rest) != len(serialScheme) {
+		return 0
+	}
+	for i := 0; i < len(rest); i++ {
+		if serialScheme[i] == 'A' && !isUpper(rest[i]) || serialScheme[i] == '0' && !isDigit(rest[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Warnings lists things about a valid serial that are suspicious, such as
+// the factory placeholder, an all-zero unit number or a serial unlike the
+// ones seen so far.
+func (s SerialNumber) Warnings(l *Layout) []string {
+	var warnings []string
+	if s.Raw == l.SkipSerial {
+		warnings = append(warnings, "this is the factory placeholder serial and will not be found again")
+	} else if rest := s.Raw[len(s.Model):]; len(rest) == len(serialScheme) && schemeMismatch(rest) >= 0 {
+		i := schemeMismatch(rest)
+		want := "a digit"
+		if serialScheme[i] == 'A' {
+			want = "a letter"
+		}
+		warnings = append(warnings, fmt.Sprintf("position %d is %q, usually %s (pattern %s after %s)", len(s.Model)+i+1, rest[i], want, serialScheme, s.Model))
+	}
+	if s.Batch == "0000" {
+		warnings = append(warnings, "batch is 0000")
+	}
+	if s.Unit == "0000" {
+		warnings = append(warnings, "unit number is 0000")
+	}
+	return warnings
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
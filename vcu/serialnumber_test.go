@@ -0,0 +1,69 @@
+package vcu
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseSerial(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		in       string
+		want     SerialNumber
+		pos      int // of the SerialError, or -1 for length errors
+		warnings []string
+	}{
+		{
+			name: "valid",
+			in:   testSerial,
+			want: SerialNumber{Raw: testSerial, Model: "1CG", Series: "CC", Batch: "1234", Line: "C", Unit: "1234"},
+		},
+		{
+			name: "lower case and spaces",
+			in:   " 1cgcc1234c1234\n",
+			want: SerialNumber{Raw: testSerial, Model: "1CG", Series: "CC", Batch: "1234", Line: "C", Unit: "1234"},
+		},
+		{
+			name:     "off the usual scheme",
+			in:       "1CGCC12X4C1234",
+			want:     SerialNumber{Raw: "1CGCC12X4C1234", Model: "1CG"},
+			warnings: []string{`position 8 is 'X', usually a digit (pattern AA0000A0000 after 1CG)`},
+		},
+		{
+			name:     "zero batch and unit",
+			in:       "1CGCC0000C0000",
+			want:     SerialNumber{Raw: "1CGCC0000C0000", Model: "1CG", Series: "CC", Batch: "0000", Line: "C", Unit: "0000"},
+			warnings: []string{"batch is 0000", "unit number is 0000"},
+		},
+		{
+			name:     "factory placeholder",
+			in:       DefaultLayout.SkipSerial,
+			want:     SerialNumber{Raw: DefaultLayout.SkipSerial, Model: "1CG"},
+			warnings: []string{"this is the factory placeholder serial and will not be found again"},
+		},
+		{name: "wrong prefix", in: "2CGCC1234C1234", pos: 0},
+		{name: "wrong prefix, later character", in: "1CHCC1234C1234", pos: 2},
+		{name: "too short", in: "1CGCC1234C123", pos: -1},
+		{name: "too long", in: "1CGCC1234C12345", pos: -1},
+		{name: "control character", in: "1CGCC1234\x01C1234", pos: 9},
+		{name: "Cyrillic look-alike", in: "1CGCС1234C1234", pos: 4},
+		{name: "punctuation", in: "1CGCC-1234C123", pos: 5},
+	} {
+		sn, err := ParseSerial(tt.in, DefaultLayout)
+		if tt.want.Raw == "" {
+			var serr *SerialError
+			if !errors.Is(err, ErrSerialFormat) || !errors.As(err, &serr) || serr.Pos != tt.pos {
+				t.Errorf("%s: %v, want a SerialError at position %d", tt.name, err, tt.pos)
+			}
+			continue
+		}
+		if err != nil || sn != tt.want {
+			t.Errorf("%s: %+v, %v; want %+v", tt.name, sn, err, tt.want)
+			continue
+		}
+		if got := sn.Warnings(DefaultLayout); !slices.Equal(got, tt.warnings) {
+			t.Errorf("%s: warnings %q, want %q", tt.name, got, tt.warnings)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package vcu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// AppSize is the largest application the application region, and so the
+// staging area, can hold.
+const AppSize = AppEnd - AppOffset
+
+// RAM of the STM32, where the initial stack pointer of an image must be.
+const (
+	ramBase = 0x20000000
+	ramEnd  = 0x20010000
+)
+
+var ErrAppImage = errors.New("vcu: not an application image")
+
+// AppImage returns the application to install from data, which is either
+// a bare application linked at 0x08001000 or a full image to take the
+// application region from. Trailing erased bytes are dropped and the
+// result is padded to whole flash words. The vector table is checked so a
+// dump or a random file is not mistaken for an application.
+func AppImage(data []byte) ([]byte, error) {
+	if len(data) == DumpSize {
+		data = data[AppOffset:AppEnd]
+	}
+	app := bytes.TrimRight(data, "\xff")
+	if len(app) < 8 || len(app) > AppSize {
+		return nil, fmt.Errorf("%w: %d bytes, want 8–%d", ErrAppImage, len(app), AppSize)
+	}
+
+	if err := checkVectors(app); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppImage, err)
+	}
+
+	app = bytes.Clone(app)
+	for len(app)%4 != 0 {
+		app = append(app, 0xFF)
+	}
+	return app, nil
+}
+
+// SetStaging erases the staging area and writes app to its start. The
+// running application and the config pages are left alone.
+func (d *Dump) SetStaging(app []byte) error {
+	switch {
+	case d.Partial():
+		return fmt.Errorf("%w: config-only dump has no staging area", ErrSize)
+	case len(app) > StagingEnd-StagingOffset:
+		return fmt.Errorf("%w: %d bytes do not fit the staging area", ErrAppImage, len(app))
+	}
+	staging := d.data[StagingOffset:StagingEnd]
+	for i := range staging {
+		staging[i] = 0xFF
+	}
+	copy(staging, app)
+	return nil
+}
+
+// checkVectors checks the start of the vector table of an image linked at
+// 0x08001000: the initial stack pointer and the reset handler.
+func checkVectors(app []byte) error {
+	if len(app) < 8 {
+		return fmt.Errorf("%d bytes are too short for a vector table", len(app))
+	}
+	sp := binary.LittleEndian.Uint32(app)
+	reset := binary.LittleEndian.Uint32(app[4:])
+	switch {
+	case sp < ramBase || sp > ramEnd:
+		return fmt.Errorf("initial stack pointer 0x%08X is not in RAM", sp)
+	case reset&1 == 0 || reset < FlashBase+AppOffset || reset >= FlashBase+AppEnd:
+		return fmt.Errorf("reset vector 0x%08X is not in the application region", reset)
+	}
+	return nil
+}
+
+// Stage writes app to the staging area and arms the update descriptor, so
+// the bootloader replaces the application with it on the next boot. The
+// application region and the config copies are not touched. Like
+// ArmUpdate, it fails for a bootloader the descriptor layout was not
+// verified for.
+func (d *Dump) Stage(app []byte) error {
+	if err := d.SetStaging(app); err != nil {
+		return err
+	}
+	return d.ArmUpdate(len(app))
+}
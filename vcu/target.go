@@ -0,0 +1,70 @@
+package vcu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrVerify = errors.New("vcu: flash read-back does not match")
+
+// Target is a connection to a VCU that can read and program its flash.
+// Offsets are relative to FlashBase.
+type Target interface {
+	// ReadFlash reads n bytes starting at offset.
+	ReadFlash(offset, n int) ([]byte, error)
+	// ProgramPage erases the page at offset and programs data into it.
+	// data is exactly PageSize bytes.
+	ProgramPage(offset int, data []byte) error
+}
+
+// ReadTarget reads the flash of t into a dump. With configOnly set only the
+// config sector is read, which gives a config-only dump.
+func ReadTarget(t Target, configOnly bool) (*Dump, error) {
+	start := 0
+	if configOnly {
+		start = ConfigAOffset
+	}
+	data := make([]byte, 0, DumpSize-start)
+	for offset := start; offset < DumpSize; offset += PageSize {
+		b, err := t.ReadFlash(offset, PageSize)
+		if err != nil {
+			return nil, fmt.Errorf("reading 0x%08X: %w", FlashBase+offset, err)
+		}
+		data = append(data, b...)
+	}
+	return Parse(data)
+}
+
+// ChangedPages returns the offsets of the pages that differ between two
+// dumps, limited to what both of them contain.
+func ChangedPages(a, b *Dump) []int {
+	var pages []int
+	for offset := max(a.start, b.start); offset < DumpSize; offset += PageSize {
+		if !bytes.Equal(a.data[offset:offset+PageSize], b.data[offset:offset+PageSize]) {
+			pages = append(pages, offset)
+		}
+	}
+	return pages
+}
+
+// WriteTarget programs the pages of d that differ from current, the dump
+// read from t before, and reads each one back to verify it. It returns the
+// offsets of the pages written.
+func WriteTarget(t Target, current, d *Dump) ([]int, error) {
+	pages := ChangedPages(current, d)
+	for i, offset := range pages {
+		page := d.data[offset : offset+PageSize]
+		if err := t.ProgramPage(offset, page); err != nil {
+			return pages[:i], fmt.Errorf("programming 0x%08X: %w", FlashBase+offset, err)
+		}
+		got, err := t.ReadFlash(offset, PageSize)
+		if err != nil {
+			return pages[:i], fmt.Errorf("verifying 0x%08X: %w", FlashBase+offset, err)
+		}
+		if !bytes.Equal(got, page) {
+			return pages[:i], fmt.Errorf("%w at 0x%08X", ErrVerify, FlashBase+offset)
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,132 @@
+package vcu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// ManifestName is the file listing the templates of a template directory.
+const ManifestName = "manifest.json"
+
+var (
+	ErrManifest         = errors.New("vcu: invalid template manifest")
+	ErrTemplateNotFound = errors.New("vcu: template not found")
+	ErrTemplateHash     = errors.New("vcu: template checksum mismatch")
+)
+
+// Template is a firmware image the custom flow can start from.
+type Template struct {
+	Version string `json:"version"`
+	Label   string `json:"label,omitempty"`
+	File    string `json:"file"`
+	SHA256  string `json:"sha256"`
+	Layout  string `json:"layout,omitempty"`
+	// AppSHA256 is registered with RegisterBuild so dumps of this firmware
+	// are recognised by hash.
+	AppSHA256 string `json:"app_sha256,omitempty"`
+}
+
+func (t Template) String() string {
+	if t.Label != "" {
+		return t.Label
+	}
+	return t.Version
+}
+
+type Manifest struct {
+	Templates []Template `json:"templates"`
+}
+
+// TemplateStore serves the templates listed in a manifest from a file
+// system, which may be a directory or an embedded FS.
+type TemplateStore struct {
+	fsys     fs.FS
+	Manifest Manifest
+}
+
+// OpenTemplates reads the manifest at the root of fsys.
+func OpenTemplates(fsys fs.FS) (*TemplateStore, error) {
+	data, err := fs.ReadFile(fsys, ManifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TemplateStore{fsys: fsys}
+	if err = json.Unmarshal(data, &s.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifest, err)
+	}
+	for _, t := range s.Manifest.Templates {
+		if t.Version == "" || t.File == "" || len(t.SHA256) != sha256.Size*2 {
+			return nil, fmt.Errorf("%w: entry %q needs version, file and sha256", ErrManifest, t.File)
+		}
+		if t.AppSHA256 != "" {
+			RegisterBuild(t.Version, t.AppSHA256)
+		}
+	}
+	return s, nil
+}
+
+// NewTemplateStore serves the templates in m from fsys, for a template
+// directory without a manifest. Unlike OpenTemplates it registers no builds,
+// since nothing vouches for the files.
+func NewTemplateStore(fsys fs.FS, m Manifest) *TemplateStore {
+	return &TemplateStore{fsys: fsys, Manifest: m}
+}
+
+// Templates returns the manifest entries in manifest order.
+func (s *TemplateStore) Templates() []Template {
+	return s.Manifest.Templates
+}
+
+// Find returns the template for a version.
+func (s *TemplateStore) Find(version string) (Template, error) {
+	for _, t := range s.Manifest.Templates {
+		if t.Version == version {
+			return t, nil
+		}
+	}
+	return Template{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, version)
+}
+
+// Load reads a template, checks its SHA-256 against the manifest and applies
+// its layout profile.
+func (s *TemplateStore) Load(t Template) (*Dump, error) {
+	data, err := fs.ReadFile(s.fsys, t.File)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, t.SHA256) {
+		return nil, fmt.Errorf("%w: %s is %s, manifest says %s", ErrTemplateHash, t.File, got, t.SHA256)
+	}
+
+	d, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if t.Layout != "" {
+		l, ok := LookupLayout(t.Layout)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s: unknown layout %q", ErrManifest, t.File, t.Layout)
+		}
+		d.SetLayout(l)
+	}
+	return d, nil
+}
+
+// NewTemplate describes data stored as file, for writing a manifest.
+func NewTemplate(version, file string, data []byte) Template {
+	sum := sha256.Sum256(data)
+	t := Template{Version: version, File: file, SHA256: hex.EncodeToString(sum[:])}
+	if d, err := Parse(data); err == nil {
+		t.Layout = d.Layout().Name
+		t.AppSHA256 = d.AppSHA256()
+	}
+	return t
+}